@@ -0,0 +1,679 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Harsh-bartariya/tor-history/output"
+	"github.com/Harsh-bartariya/tor-history/query"
+	"github.com/Harsh-bartariya/tor-history/store"
+)
+
+// Parses a time argument (-at), see store.ParseTime
+func parseTimeArg(ts string, def string) string {
+	t, err := store.ParseTime(ts, def)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return t
+}
+
+// Parses -from/-to arguments, see store.ParseTimeRange
+func parseTimeRangeArgs(from string, to string) (string, string) {
+	tsFrom, tsTo, err := store.ParseTimeRange(from, to, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tsFrom, tsTo
+}
+
+// -from/-to/-at flags scoping a lookup command to a time range
+type timeRangeFlags struct {
+	from, to, at *string
+}
+
+func addTimeRangeFlags(fs *flag.FlagSet) timeRangeFlags {
+	return timeRangeFlags{
+		from: fs.String("from", "", "Only match records seen at or after this time"),
+		to:   fs.String("to", "", "Only match records seen at or before this time"),
+		at:   fs.String("at", "", "Only match records valid at this time (excludes -from/-to)"),
+	}
+}
+
+// The [from, to] range of the flags, see store.ParseTimeRange
+func (tf timeRangeFlags) parse(command string) (string, string) {
+	from, to, err := store.ParseTimeRange(*tf.from, *tf.to, *tf.at)
+	if err != nil {
+		log.Fatal(command+": ", err)
+	}
+	return from, to
+}
+
+// -output flag of the query commands
+func addOutputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", "text", "Output format: text, "+strings.Join(output.Formats, ", "))
+}
+
+// Prints the records, one value per column, in an output format other than text
+func printRecords(format string, columns []string, records [][]interface{}) {
+	out, err := output.New(os.Stdout, format, columns...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range records {
+		if err := out.Write(r...); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Relays ordered by fingerprint
+func printRelays(relays map[string](map[string]string), format string) {
+	var sorted [](map[string]string)
+	for _, r := range relays {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i]["Fingerprint"] < sorted[j]["Fingerprint"] })
+
+	if format != "text" {
+		var records [][]interface{}
+		for _, r := range sorted {
+			records = append(records, []interface{}{r["Fingerprint"], r["Nickname"], r["Country"], r["PlatformName"],
+				r["VersionName"], r["ContactName"], r["RecordTimeInserted"], r["RecordLastSeen"]})
+		}
+		printRecords(format, []string{"fingerprint", "nickname", "country", "platform", "version", "contact", "from", "to"}, records)
+	} else {
+		for _, r := range sorted {
+			fmt.Printf("%s %-19s %-2s  %s - %s\n", r["Fingerprint"], r["Nickname"], r["Country"], r["RecordTimeInserted"], r["RecordLastSeen"])
+		}
+	}
+	ifPrintln(-1, fmt.Sprintf("%d relays found.", len(relays)))
+}
+
+// prune command
+func runPrune(args []string) {
+	fs := newFlagSet("prune", "[-dry-run] [-metrics-older-than n] [-downsample-older-than n] [-gc]",
+		"Removes old snapshots and unreferenced lookup rows according to the retention policies.\n"+
+			"Relay uptime only counts the snapshots left, and a later import into a pruned period extends the presence intervals across the removed snapshots.\n"+
+			"Needs DELETE on the network summaries and lookup tables, which the import user (tor-rw) lacks: run it as tor-admin")
+	dryRun := fs.Bool("dry-run", false, "Report the number of rows each policy would remove: the deletions are rolled back, leaving the database unchanged")
+	metricsMonths := fs.Int("metrics-older-than", 0, "Drop snapshots (TorQueries) older than this number of months (0 disables; default: the configured retention)")
+	downsampleMonths := fs.Int("downsample-older-than", 0, "Keep only the first snapshot of the day for snapshots older than this number of months (0 disables; default: the configured retention)")
+	gc := fs.Bool("gc", false, "Remove lookup rows (contacts, platforms, exit policies...) which are no longer referenced (default: the configured retention)")
+	parseFlags(fs, args)
+
+	// The configuration is loaded with the flags; the retention applies where they are not set
+	set := flagsSet(fs)
+	if !set["metrics-older-than"] {
+		*metricsMonths = g_config.Retention.MetricsMonths
+	}
+	if !set["downsample-older-than"] {
+		*downsampleMonths = g_config.Retention.DownsampleMonths
+	}
+	if !set["gc"] {
+		*gc = g_config.Retention.GC
+	}
+
+	if !g_db.Initialized() {
+		log.Fatal("prune: requires a database configuration (-config-filename). Note it needs DELETE privileges.")
+	}
+
+	policies := store.BuildPrunePolicies(*metricsMonths, *downsampleMonths, *gc)
+	if len(policies) == 0 {
+		log.Fatal("prune: no retention policy selected. Use -metrics-older-than, -downsample-older-than or -gc.")
+	}
+	g_db.Prune(policies, *dryRun)
+}
+
+// migrate command
+func runMigrate(args []string) {
+	fs := newFlagSet("migrate", "[-schema fn] [-dry-run] [-parse-contacts]",
+		"Upgrades the database to the current schema: creates the missing tables and applies the upgrades of sql-upgrade.sql which are missing")
+	schema := fs.String("schema", "sql-schema.sql", "Schema file with the CREATE TABLE statements of the missing tables")
+	dryRun := fs.Bool("dry-run", false, "Only list the missing migrations")
+	parseContacts := fs.Bool("parse-contacts", false, "Parse the details of the existing contacts again, after the migrations")
+	fs.Parse(args)
+	// The database is opened after the migrations: its statements need the current schema
+	parseConfigFile(g_cfgFilename, &g_config)
+
+	if !g_config.DBServer.Enabled {
+		log.Fatal("migrate: requires a database configuration (-config-filename). Note it needs CREATE, ALTER and INDEX privileges.")
+	}
+	data, err := ioutil.ReadFile(*schema)
+	if err != nil {
+		log.Fatal("migrate: ", err)
+	}
+	store.Migrate(g_config, store.BuildMigrations(string(data)), *dryRun)
+
+	if *parseContacts && !*dryRun {
+		reparseContacts()
+	}
+}
+
+// Parses the details of the existing contacts again (the former parse-contacts command)
+func reparseContacts() {
+	openDB()
+	if !g_db.Initialized() {
+		log.Fatal("parse-contacts: requires a database configuration (-config-filename).")
+	}
+	fmt.Printf("Parsed %d contacts.\n", g_db.ReparseContacts())
+}
+
+// check command
+func runCheck(args []string) {
+	fs := newFlagSet("check", "[-repair]",
+		"Checks the consistency of the database")
+	repair := fs.Bool("repair", false, "Apply the safe fixes (RLS before RTI, merging overlapping identical rows, removing unreferenced lookup rows). Needs UPDATE and DELETE privileges.")
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("check: requires a database configuration (-config-filename).")
+	}
+
+	if left := g_db.Check(store.BuildConsistencyChecks(), *repair); left > 0 {
+		fmt.Printf("%d problems found.\n", left)
+		os.Exit(1)
+	}
+	fmt.Println("No problems found.")
+}
+
+// export command
+func runExport(args []string) {
+	fs := newFlagSet("export", "[-from ts] [-to ts] [-file fn]",
+		"Exports the relay history as NDJSON")
+	from := fs.String("from", "", "Export records seen at or after this time")
+	to := fs.String("to", "", "Export records inserted at or before this time")
+	filename := fs.String("file", "-", "Output file. \"-\" is stdout; a .gz suffix compresses the output")
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("export: requires a database configuration (-config-filename)")
+	}
+
+	var w io.Writer = os.Stdout
+	if *filename != "-" {
+		f, err := os.Create(*filename)
+		if err != nil {
+			log.Fatal("export: ", err)
+		}
+		defer f.Close()
+		w = f
+		if strings.HasSuffix(*filename, ".gz") {
+			zw := gzip.NewWriter(f)
+			defer zw.Close()
+			w = zw
+		}
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	tsFrom, tsTo := parseTimeRangeArgs(*from, *to)
+	count := g_db.ExportHistory(bw, tsFrom, tsTo)
+	ifPrintln(-1, fmt.Sprintf("Exported %d records.", count))
+}
+
+// import history command
+func runImportHistory(args []string) {
+	fs := newFlagSet("import history", "-file fn [-from ts] [-to ts]",
+		"Imports a relay history created by export")
+	from := fs.String("from", "", "Import records seen at or after this time")
+	to := fs.String("to", "", "Import records inserted at or before this time")
+	filename := fs.String("file", "", "NDJSON history file created by export. \"-\" is stdin; .gz files are decompressed")
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("import-history: requires a database configuration (-config-filename)")
+	}
+	if *filename == "" {
+		log.Fatal("import-history: -file is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if *filename != "-" {
+		f, err := os.Open(*filename)
+		if err != nil {
+			log.Fatal("import-history: ", err)
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(*filename, ".gz") {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				log.Fatal("import-history: ", err)
+			}
+			defer zr.Close()
+			r = zr
+		}
+	}
+
+	// The lookup caches are needed to resolve the values to IDs
+	g_db.InitCaches(time.Now().Format(store.DLTSFmt))
+	g_db.InitCountryNameCache()
+
+	tsFrom, tsTo := parseTimeRangeArgs(*from, *to)
+	read, inserted := g_db.ImportHistory(r, tsFrom, tsTo)
+	ifPrintln(-1, fmt.Sprintf("Read %d records, inserted %d.", read, inserted))
+}
+
+// query ip command
+func runIP(args []string) {
+	fs := newFlagSet("query ip", "[-port p] [-at ts | -from ts -to ts] address|cidr",
+		"Relays which used an IP address or an address of a CIDR block, with their roles and intervals")
+	port := fs.String("port", "", "Only match OR and directory addresses on this port (exit addresses always match)")
+	timeRange := addTimeRangeFlags(fs)
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("ip: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() != 1 {
+		log.Fatal("ip: exactly one IP address or CIDR block is required")
+	}
+	if *port != "" {
+		if _, err := strconv.ParseUint(*port, 10, 16); err != nil {
+			log.Fatal("ip: invalid port: " + *port)
+		}
+	}
+
+	tsFrom, tsTo := timeRange.parse("ip")
+	q := query.New(g_db)
+	var matches []query.AddressMatch
+	if strings.Contains(fs.Arg(0), "/") {
+		matches = q.ByCIDR(fs.Arg(0), *port, tsFrom, tsTo)
+	} else {
+		matches = q.ByIPAt(fs.Arg(0), *port, tsFrom, tsTo)
+	}
+	if *format != "text" {
+		var records [][]interface{}
+		for _, m := range matches {
+			records = append(records, []interface{}{m.Role, m.IP, m.Port, m.From, m.To, m.Fingerprint, m.Relay["Nickname"]})
+		}
+		printRecords(*format, []string{"role", "ip", "port", "from", "to", "fingerprint", "nickname"}, records)
+	} else {
+		for _, m := range matches {
+			fmt.Printf("%-14s %-39s %5s  %s  %s  %s %s\n", m.Role, m.IP, m.Port, m.From, m.To, m.Fingerprint, m.Relay["Nickname"])
+		}
+	}
+	ifPrintln(-1, fmt.Sprintf("%d intervals found.", len(matches)))
+}
+
+// query as command
+func runAS(args []string) {
+	fs := newFlagSet("query as", "[-at ts | -from ts -to ts] AS1234|1234|name",
+		"Intervals relays spent in an autonomous system, given its number or part of its name")
+	timeRange := addTimeRangeFlags(fs)
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("as: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() != 1 {
+		log.Fatal("as: exactly one AS number or AS name is required")
+	}
+
+	tsFrom, tsTo := timeRange.parse("as")
+	intervals := query.New(g_db).ByAS(fs.Arg(0), tsFrom, tsTo)
+	if *format != "text" {
+		var records [][]interface{}
+		for _, i := range intervals {
+			records = append(records, []interface{}{i.AS, i.ASName, i.From, i.To, i.Fingerprint, i.Relay["Nickname"]})
+		}
+		printRecords(*format, []string{"as", "as_name", "from", "to", "fingerprint", "nickname"}, records)
+	} else {
+		for _, i := range intervals {
+			fmt.Printf("%-10s %s  %s  %s %-19s %s\n", i.AS, i.From, i.To, i.Fingerprint, i.Relay["Nickname"], i.ASName)
+		}
+	}
+	ifPrintln(-1, fmt.Sprintf("%d intervals found.", len(intervals)))
+}
+
+// query versions command
+func runVersions(args []string) {
+	fs := newFlagSet("query versions", "[-at ts]",
+		"Number of relays running each Tor version")
+	at := fs.String("at", "", "Versions of the relays running at this time (default: now)")
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("versions: requires a database configuration (-config-filename)")
+	}
+
+	total := 0
+	var records [][]interface{}
+	for _, c := range query.New(g_db).VersionDistribution(parseTimeArg(*at, time.Now().UTC().Format(store.TimeFmt))) {
+		if *format != "text" {
+			records = append(records, []interface{}{c.Version, c.Status, c.Relays})
+		} else {
+			fmt.Printf("%6d  %-20s %s\n", c.Relays, c.Version, c.Status)
+		}
+		total += c.Relays
+	}
+	if *format != "text" {
+		printRecords(*format, []string{"version", "status", "relays"}, records)
+	}
+	ifPrintln(-1, fmt.Sprintf("%d relays running.", total))
+}
+
+// query unrecommended command
+func runUnrecommended(args []string) {
+	fs := newFlagSet("query unrecommended", "[-at ts | -from ts -to ts]",
+		"Relays which ran an obsolete or unrecommended Tor version")
+	timeRange := addTimeRangeFlags(fs)
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("unrecommended: requires a database configuration (-config-filename)")
+	}
+	from, to := timeRange.parse("unrecommended")
+	relays := query.New(g_db).ByUnrecommendedVersion(from, to)
+	if *format != "text" {
+		printRelays(relays, *format)
+		return
+	}
+	for _, r := range relays {
+		fmt.Printf("%s %-19s %-20s %s - %s\n", r["Fingerprint"], r["Nickname"], r["VersionName"], r["RecordTimeInserted"], r["RecordLastSeen"])
+	}
+	ifPrintln(-1, fmt.Sprintf("%d relays found.", len(relays)))
+}
+
+// query contact-search command
+func runContactSearch(args []string) {
+	fs := newFlagSet("query contact-search", "[-at ts | -from ts -to ts] words|email|domain",
+		"Contacts matching words, an e-mail address or a domain, with the relays which used them")
+	timeRange := addTimeRangeFlags(fs)
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("contact-search: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() == 0 {
+		log.Fatal("contact-search: words, an e-mail address or a domain are required")
+	}
+	text := strings.Join(fs.Args(), " ")
+	if _, ftQuery := store.ContactSearchQuery(text); ftQuery == "" {
+		log.Fatal("contact-search: no searchable words (at least 3 characters, not a stopword) in: " + text)
+	}
+
+	tsFrom, tsTo := timeRange.parse("contact-search")
+	matches := query.New(g_db).SearchContacts(text, tsFrom, tsTo)
+	if *format != "text" {
+		var records [][]interface{}
+		for _, m := range matches {
+			records = append(records, []interface{}{m.Score, m.Contact, m.From, m.To, m.Fingerprint, m.Relay["Nickname"]})
+		}
+		printRecords(*format, []string{"score", "contact", "from", "to", "fingerprint", "nickname"}, records)
+		ifPrintln(-1, fmt.Sprintf("%d intervals found.", len(matches)))
+		return
+	}
+	contact := ""
+	for _, m := range matches {
+		if m.Contact != contact {
+			contact = m.Contact
+			fmt.Printf("%.3f  %s\n", m.Score, contact)
+		}
+		fmt.Printf("       %s  %s  %s %s\n", m.From, m.To, m.Fingerprint, m.Relay["Nickname"])
+	}
+	ifPrintln(-1, fmt.Sprintf("%d intervals found.", len(matches)))
+}
+
+// query timeline command
+func runTimeline(args []string) {
+	fs := newFlagSet("query timeline", "fingerprint",
+		"Every recorded version of a relay")
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("timeline: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() != 1 {
+		log.Fatal("timeline: exactly one relay fingerprint is required")
+	}
+
+	timeline := query.New(g_db).Timeline(fs.Arg(0))
+	if *format != "text" {
+		var records [][]interface{}
+		for _, e := range timeline {
+			r := e.Relay
+			records = append(records, []interface{}{e.From, e.To, r.Fingerprint, r.Nickname, r.Country, r.As, r.Version,
+				r.Platform, r.Contact, r.Flags, r.Exit_policy, e.Addresses, e.Host_names})
+		}
+		printRecords(*format, []string{"from", "to", "fingerprint", "nickname", "country", "as", "version",
+			"platform", "contact", "flags", "exit_policy", "addresses", "host_names"}, records)
+		ifPrintln(-1, fmt.Sprintf("%d versions found.", len(timeline)))
+		return
+	}
+	for _, e := range timeline {
+		r := e.Relay
+		fmt.Printf("%s - %s  %s  %s/%s  %s  %s\n", e.From, e.To, r.Nickname, r.Country, r.As, r.Version, strings.Join(r.Flags, ","))
+		if r.Platform != "" {
+			fmt.Printf("    platform: %s\n", r.Platform)
+		}
+		if r.Contact != "" {
+			fmt.Printf("    contact:  %s\n", r.Contact)
+		}
+		if len(r.Exit_policy) > 0 {
+			fmt.Printf("    policy:   %s\n", strings.Join(r.Exit_policy, ", "))
+		}
+		for _, a := range e.Addresses {
+			fmt.Printf("    %-14s %-39s %5s  %s - %s\n", a.Role, a.Address, a.Port, a.First_seen, a.Last_seen)
+		}
+		for _, h := range e.Host_names {
+			fmt.Printf("    %-14s %-45s  %s - %s\n", "host-name", h.Host_name, h.First_seen, h.Last_seen)
+		}
+	}
+	ifPrintln(-1, fmt.Sprintf("%d versions found.", len(timeline)))
+}
+
+// query relay-changes command
+func runRelayChanges(args []string) {
+	fs := newFlagSet("query relay-changes", "fingerprint",
+		"Field changes between the versions of a relay")
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("relay-changes: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() != 1 {
+		log.Fatal("relay-changes: exactly one relay fingerprint is required")
+	}
+
+	changes := query.New(g_db).Changes(fs.Arg(0))
+	var records [][]interface{}
+	for _, rc := range changes {
+		for _, c := range rc.Changes {
+			if *format != "text" {
+				records = append(records, []interface{}{rc.At, c.Field, c.Old, c.New})
+			} else {
+				fmt.Printf("%s  %-28s %s => %s\n", rc.At, c.Field, c.Old, c.New)
+			}
+		}
+	}
+	if *format != "text" {
+		printRecords(*format, []string{"at", "field", "old", "new"}, records)
+	}
+	ifPrintln(-1, fmt.Sprintf("%d changes found.", len(changes)))
+}
+
+// query nickname command
+func runNickname(args []string) {
+	fs := newFlagSet("query nickname", "[-mode m] [-distance n] [-at ts | -from ts -to ts] pattern",
+		"Relays which used a nickname matching the pattern")
+	mode := fs.String("mode", "exact", "Match mode: "+strings.Join(store.NicknameMatchModes, ", "))
+	distance := fs.Int("distance", 2, "Maximum edit distance for the fuzzy mode")
+	timeRange := addTimeRangeFlags(fs)
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("nickname: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() != 1 {
+		log.Fatal("nickname: exactly one pattern is required")
+	}
+
+	from, to := timeRange.parse("nickname")
+	relays, err := query.New(g_db).ByNickname(fs.Arg(0), *mode, *distance, from, to)
+	if err != nil {
+		log.Fatal("nickname: ", err)
+	}
+	printRelays(relays, *format)
+}
+
+// query exit-policy command
+func runExitPolicy(args []string) {
+	fs := newFlagSet("query exit-policy", "[-at ts] fingerprint ip port",
+		"Whether the relay allowed exiting to the destination")
+	at := fs.String("at", "", "Evaluate the policy the relay had at this time (default: now)")
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("exit-policy: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() != 3 {
+		log.Fatal("exit-policy: a relay fingerprint, a destination IP and a port are required")
+	}
+	port, err := strconv.ParseUint(fs.Arg(2), 10, 16)
+	if err != nil {
+		log.Fatal("exit-policy: invalid port: " + fs.Arg(2))
+	}
+
+	ts := parseTimeArg(*at, time.Now().UTC().Format(store.TimeFmt))
+	allowed, found, reason, err := query.New(g_db).ExitAllowed(fs.Arg(0), ts, fs.Arg(1), uint16(port))
+	if err != nil {
+		log.Fatal("exit-policy: ", err)
+	}
+	if *format != "text" {
+		printRecords(*format, []string{"fingerprint", "at", "ip", "port", "found", "allowed", "reason"},
+			[][]interface{}{{fs.Arg(0), ts, fs.Arg(1), port, found, allowed, reason}})
+	}
+	if !found {
+		if *format == "text" {
+			fmt.Printf("Relay %s was not recorded at %s.\n", fs.Arg(0), ts)
+		}
+		os.Exit(1)
+	}
+	if *format != "text" {
+		return
+	}
+	verdict := "REJECT"
+	if allowed {
+		verdict = "ACCEPT"
+	}
+	fmt.Printf("%s (%s)\n", verdict, reason)
+}
+
+// query exits-reaching command
+func runExitsReaching(args []string) {
+	fs := newFlagSet("query exits-reaching", "[-at ts] ip port",
+		"Exits whose policy allowed reaching the destination")
+	at := fs.String("at", "", "Relays which could exit to the destination at this time (default: now)")
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("exits-reaching: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() != 2 {
+		log.Fatal("exits-reaching: a destination IP and a port are required")
+	}
+	port, err := strconv.ParseUint(fs.Arg(1), 10, 16)
+	if err != nil {
+		log.Fatal("exits-reaching: invalid port: " + fs.Arg(1))
+	}
+
+	matches, err := query.New(g_db).ExitsReaching(fs.Arg(0), uint16(port), parseTimeArg(*at, time.Now().UTC().Format(store.TimeFmt)))
+	if err != nil {
+		log.Fatal("exits-reaching: ", err)
+	}
+	if *format != "text" {
+		var records [][]interface{}
+		for _, m := range matches {
+			records = append(records, []interface{}{m.Fingerprint, m.Nickname, m.ExitAddresses, m.Reason})
+		}
+		printRecords(*format, []string{"fingerprint", "nickname", "exit_addresses", "reason"}, records)
+	} else {
+		for _, m := range matches {
+			fmt.Printf("%s %-19s %-40s %s\n", m.Fingerprint, m.Nickname, strings.Join(m.ExitAddresses, ","), m.Reason)
+		}
+	}
+	ifPrintln(-1, fmt.Sprintf("%d exits found.", len(matches)))
+}
+
+// query lookup command
+func runLookup(args []string) {
+	fs := newFlagSet("query lookup", "[-at ts | -from ts -to ts] cc|email|ip|hostname|domain|pgp|as|version|platform value",
+		"Relays matching a country code, e-mail, IP, host name, contact domain, PGP key, AS, Tor version or platform")
+	timeRange := addTimeRangeFlags(fs)
+	format := addOutputFlag(fs)
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("lookup: requires a database configuration (-config-filename)")
+	}
+	if fs.NArg() != 2 {
+		log.Fatal("lookup: a lookup type (cc, email, ip, hostname, domain, pgp, as, version or platform) and a value are required")
+	}
+	from, to := timeRange.parse("lookup")
+
+	relays, err := lookupRelays(query.New(g_db), fs.Arg(0), fs.Arg(1), from, to)
+	if err != nil {
+		log.Fatal("lookup: ", err)
+	}
+	printRelays(relays, *format)
+}
+
+// Lookup types of the lookup command and the lookup API
+var lookupTypes = []string{"cc", "email", "ip", "hostname", "domain", "pgp", "as", "version", "platform"}
+
+// Relays matching the value of a lookup type in the [from, to] time range
+func lookupRelays(q *query.Client, lookupType string, value string, from string, to string) (map[string](map[string]string), error) {
+	switch lookupType {
+	case "cc":
+		return q.ByCountryCode(value, from, to), nil
+	case "email":
+		return q.ByEmail(value, from, to), nil
+	case "ip":
+		return q.ByIP(value, from, to), nil
+	case "hostname":
+		return q.ByHostName(value, from, to), nil
+	case "domain":
+		return q.ByContactDomain(value, from, to), nil
+	case "pgp":
+		return q.ByPGP(value, from, to), nil
+	case "as":
+		return query.LatestASRelays(q.ByAS(value, from, to)), nil
+	case "version":
+		return q.ByVersion(value, from, to), nil
+	case "platform":
+		return q.ByPlatform(value, from, to), nil
+	}
+	return nil, fmt.Errorf("unknown lookup type: %s", lookupType)
+}
@@ -0,0 +1,262 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/config"
+	"github.com/Harsh-bartariya/tor-history/importer"
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+	"github.com/Harsh-bartariya/tor-history/output"
+)
+
+// Consensus source flags, shared by import and print
+type sourceFlags struct {
+	importFile              *string
+	consensusDownloadTime   *string
+	consensusDownloadFmt    *string
+	extractDLTfromFilename  *bool
+	extractDLTfromFilenameR *string
+}
+
+func addSourceFlags(fs *flag.FlagSet) *sourceFlags {
+	return &sourceFlags{
+		importFile:              fs.String("import-data-file", "", "Use import file instead of downloading from the consensus. A pattern (e.g. \"backup-*\") imports every matching file"),
+		consensusDownloadTime:   fs.String("consensus-download-time", "", "The time the consensus was downloaded. Useful when importing data downloaded in the past"),
+		consensusDownloadFmt:    fs.String("consensus-download-time-format", "", "The time the consensus was downloaded. Useful when importing data downloaded in the past"),
+		extractDLTfromFilename:  fs.Bool("extract-consensus-download-time-from-filename", false, "When importing from a file, it attempts to read the consensus download date from the filename"),
+		extractDLTfromFilenameR: fs.String("filename-regex", "", "When importing from a file and attempting to extract the timestamp from its name, this regex will be used"),
+	}
+}
+
+func (f *sourceFlags) apply(command string) {
+	if len(*f.importFile) == 0 &&
+		(*f.extractDLTfromFilename ||
+			len(*f.consensusDownloadTime) > 0 ||
+			len(*f.consensusDownloadFmt) > 0 ||
+			len(*f.extractDLTfromFilenameR) > 0) {
+		log.Fatal(command + ": incompatible argument. You cannot use -consensus-download-time, -consensus-download-time-format, -extract-consensus-download-time-from-filename or -filename-regex if -import-data-file is not defined.")
+	}
+
+	if *f.importFile != "" { // This overrides download
+		g_config.Tor.Filename = *f.importFile
+	}
+	g_config.Tor.ConsensusDLT = *f.consensusDownloadTime
+	g_config.Tor.ConsensusDLT_fmt = *f.consensusDownloadFmt
+	g_config.Tor.ExtractDLTfromFilename = *f.extractDLTfromFilename
+	g_config.Tor.ExtractDLTfromFilename_regex = *f.extractDLTfromFilenameR
+
+	if len(g_config.Tor.ExtractDLTfromFilename_regex) > 0 { // If regex for file extraction is specified then force file extraction bit
+		g_config.Tor.ExtractDLTfromFilename = true
+	}
+	if g_config.Tor.ExtractDLTfromFilename && len(g_config.Tor.ConsensusDLT) > 0 {
+		log.Fatalln(command + ": incompatible flags extract-consensus-download-time-from-filename and consensus-download-time. Remove one of them.")
+	}
+}
+
+// Flags of the import command, also accepted without a command
+type importFlags struct {
+	source       *sourceFlags
+	backup       *string
+	backupGzip   *bool
+	reinitCaches *int
+}
+
+func addImportFlags(fs *flag.FlagSet) *importFlags {
+	return &importFlags{
+		source:       addSourceFlags(fs),
+		backup:       fs.String("consensus-backup-file", "", "Make a backup of the consensus as downloaded at the supplied destination path/prefix. Timestamp is automatically appended."),
+		backupGzip:   fs.Bool("consensus-backup-gzip", false, "GZip the backup file"),
+		reinitCaches: fs.Int("reinit-caches-every", 100, "During bulk import, resets download timestamp (DLTS) and reinitializes the caches from DB using the new DLTS"),
+	}
+}
+
+func (f *importFlags) apply() {
+	f.source.apply("import")
+	if *f.backup != "" { // If backup file name and compression supplied on command line
+		g_config.Backup.Filename = *f.backup
+		g_config.Backup.Gzip = *f.backupGzip
+	}
+	g_config.DBServer.ReInitCaches = *f.reinitCaches
+}
+
+// Print and filter flags, shared by import and print
+type printFlags struct {
+	format, separator, fields, template                           *string
+	nickname, fingerprint, orAddresses, exitAddresses, dirAddress *bool
+	country, as, hostname, flags, ipPerLine, nodeInfo             *bool
+
+	running, hibernating *bool
+	nodeFilter           *string
+}
+
+func addPrintFlags(fs *flag.FlagSet) *printFlags {
+	return &printFlags{
+		format:        fs.String("output", "text", "Output format of the printed fields: text (joined with -separator), "+strings.Join(output.Formats, ", ")),
+		separator:     fs.String("separator", ",", "Separator to be used when data is printed on screen."),
+		fields:        fs.String("fields", "", "Comma separated relay fields to print, instead of the options below: any details document field (e.g. platform, bandwidth_rate, exit_probability), a path into it (e.g. exit_policy_summary.accept, or_addresses.0), or host_names"),
+		template:      fs.String("template", "", "Go text/template printing each relay (a TorRelayDetails), e.g. '{{.Nickname}} {{join .Or_addresses \",\"}}'. Functions: join, json, field"),
+		nickname:      fs.Bool("nick", false, "Print node nickname"),
+		fingerprint:   fs.Bool("fp", false, "Print node fingerprint"),
+		orAddresses:   fs.Bool("or", false, "Print node relay addresses"),
+		exitAddresses: fs.Bool("ex", false, "Print node exit addresses"),
+		dirAddress:    fs.Bool("di", false, "Print node directory addresses"),
+		country:       fs.Bool("country", false, "Print node country"),
+		as:            fs.Bool("as", false, "Print node autonomous system"),
+		hostname:      fs.Bool("hostname", false, "Print node host names (verified and unverified reverse DNS)"),
+		flags:         fs.Bool("flags", false, "Print node flags"),
+		ipPerLine:     fs.Bool("ip-per-line", false, "If a field has more than one IP in an array, this forces them to be on separate lines and duplicates the rest of the information"),
+		nodeInfo:      fs.Bool("node-info", false, "Generic node information (shortcut for: nickname, fingerprint, hostname, and exit addresses)"),
+
+		running:     fs.Bool("run", false, "Print nodes which are in rnning state"),
+		hibernating: fs.Bool("hib", false, "Print nodes which are in hibernating state"),
+		nodeFilter:  fs.String("filter", "", "Node flag filter: BadExit, Exit, Fast, Guard, HSDir, Running, Stable, StaleDesc, V2Dir and Valid"),
+	}
+}
+
+func (f *printFlags) apply() {
+	p := &g_config.Print
+	if *f.format != "text" {
+		if err := output.CheckFormat(*f.format); err != nil {
+			log.Fatal(err)
+		}
+	}
+	p.Output = *f.format
+	if *f.fields != "" {
+		p.Fields = strings.Split(*f.fields, ",")
+		if err := importer.CheckPrintFields(p.Fields); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *f.template != "" && *f.format != "text" {
+		log.Fatal("-template cannot be combined with -output " + *f.format)
+	}
+	p.Template = *f.template
+	p.Separator = *f.separator
+	p.Nickname = *f.nickname
+	p.Fingerprint = *f.fingerprint
+	p.Or_addresses = *f.orAddresses
+	p.Exit_addresses = *f.exitAddresses
+	p.Dir_address = *f.dirAddress
+	p.Country = *f.country
+	p.AS = *f.as
+	p.Hostname = *f.hostname
+	p.Flags = *f.flags
+	p.IPperLine = *f.ipPerLine
+
+	if *f.nodeInfo {
+		p.Nickname = true
+		p.Fingerprint = true
+		p.Exit_addresses = true
+		p.Hostname = true
+	}
+
+	// Filters
+	g_config.Filter.MatchFlags = parseNodeFilters(*f.nodeFilter)
+	g_config.Filter.Running = *f.running
+	g_config.Filter.Hibernating = *f.hibernating
+	ifPrintln(1, fmt.Sprintf("Filters requested: %v", g_config.Filter.MatchFlags))
+}
+
+func parseNodeFilters(nodeFilter string) []string {
+	var matchFlags []string
+	if nodeFilter == "" {
+		ifPrintln(3, "No filters were applied")
+	} else {
+		matchFlags = strings.Split(nodeFilter, ",")
+		ifPrintln(2, fmt.Sprintf("DEBUG: nodeFlag(s) in filter: %v\n", matchFlags))
+	}
+	return matchFlags
+}
+
+// import command
+func runImport(args []string) {
+	if len(args) > 0 && args[0] == "history" {
+		runImportHistory(args[1:])
+		return
+	}
+	fs := newFlagSet("import", "[import options]",
+		"Imports the consensus, downloaded or from the -import-data-file file(s), into the database and prints the selected relays.\n"+
+			"\"tor-history import history\" imports a history created by export")
+	imp := addImportFlags(fs)
+	prt := addPrintFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		log.Fatal("import: unexpected arguments: ", fs.Args())
+	}
+	parseConfigFile(g_cfgFilename, &g_config)
+	importConsensus(imp, prt)
+}
+
+// Imports the consensus with the import and print flags applied over the configuration
+func importConsensus(imp *importFlags, prt *printFlags) {
+	imp.apply()
+	prt.apply()
+	openDB()
+	importer.New(g_db, &g_config).Import()
+}
+
+// fetch command
+func runFetch(args []string) {
+	fs := newFlagSet("fetch", "[-url u] [-gzip] [prefix]",
+		"Downloads the consensus and saves it as prefix-YYYYMMDDhhmmss, the name import -extract-consensus-download-time-from-filename expects")
+	url := fs.String("url", "", "Consensus URL (default: the configured URL, or "+config.ConsensusDetailsURL+")")
+	gzip := fs.Bool("gzip", false, "GZip the file (default: the configured backup gzip)")
+	fs.Parse(args)
+	parseConfigFile(g_cfgFilename, &g_config)
+
+	if fs.NArg() > 1 {
+		log.Fatal("fetch: at most one file prefix is allowed")
+	}
+	prefix := g_config.Backup.Filename
+	if fs.NArg() == 1 {
+		prefix = fs.Arg(0)
+	}
+	if prefix == "" {
+		log.Fatal("fetch: a file prefix is required (argument or backup filename in the configuration)")
+	}
+	if *url != "" {
+		g_config.Tor.ConsensusURL = *url
+	}
+	if !flagsSet(fs)["gzip"] {
+		*gzip = g_config.Backup.Gzip
+	}
+
+	ifPrintln(2, "Downloading Consensus details from: "+g_config.Tor.ConsensusURL)
+	data, err := onionoo.Download(g_config.Tor.ConsensusURL)
+	if err != nil {
+		log.Fatal("fetch: ", err)
+	}
+	if _, err := onionoo.Parse(data); err != nil {
+		log.Fatal("fetch: invalid consensus: ", err)
+	}
+	fmt.Println(importer.SaveConsensus(data, prefix, *gzip))
+}
+
+// print command
+func runPrint(args []string) {
+	fs := newFlagSet("print", "[print options] [-import-data-file fn]",
+		"Prints the selected fields of the relays of the consensus, downloaded or from the -import-data-file file(s). The database is not used")
+	src := addSourceFlags(fs)
+	prt := addPrintFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		log.Fatal("print: unexpected arguments: ", fs.Args())
+	}
+	parseConfigFile(g_cfgFilename, &g_config)
+
+	g_config.DBServer.Enabled = false
+	g_config.Backup.Filename = ""
+	src.apply("print")
+	prt.apply()
+	importer.New(nil, &g_config).Import()
+}
@@ -0,0 +1,240 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/config"
+	"github.com/Harsh-bartariya/tor-history/logging"
+	"github.com/Harsh-bartariya/tor-history/store"
+)
+
+var g_config config.TorHistoryConfig
+var g_db *store.DB
+
+var ifPrintln = logging.IfPrintln
+
+// Flags shared by every command. They are kept between flag sets, so that the
+// legacy form "tor-history -config-filename fn command ..." still applies them.
+var g_cfgFilename string
+var g_verbosity uint
+var g_quiet bool
+
+type command struct {
+	run      func(args []string)
+	synopsis string
+}
+
+var commands map[string]command
+var queryCommands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"import":  {runImport, "Imports the consensus (downloaded or from files), or an exported history"},
+		"fetch":   {runFetch, "Downloads the consensus and saves it to a file"},
+		"print":   {runPrint, "Prints the relays of the consensus, without a database"},
+		"query":   {runQuery, "Looks up relays in the history"},
+		"export":  {runExport, "Exports the relay history as NDJSON"},
+		"serve":   {runServe, "Serves the lookups as a JSON HTTP API"},
+		"migrate": {runMigrate, "Upgrades the database schema"},
+		"check":   {runCheck, "Checks the consistency of the database"},
+		"prune":   {runPrune, "Removes old snapshots according to the retention policies"},
+		"maltego": {runMaltego, "Runs the Maltego local transform (built with -tags maltego)"},
+	}
+	queryCommands = map[string]command{
+		"lookup":         {runLookup, "Relays matching a country code, e-mail, IP, host name, domain, PGP key, AS, version or platform"},
+		"ip":             {runIP, "Relays which used an IP address or CIDR block, with their roles and intervals"},
+		"as":             {runAS, "Intervals relays spent in an autonomous system"},
+		"nickname":       {runNickname, "Relays which used a nickname matching a pattern"},
+		"timeline":       {runTimeline, "Every recorded version of a relay"},
+		"relay-changes":  {runRelayChanges, "Field changes between the versions of a relay"},
+		"exit-policy":    {runExitPolicy, "Whether a relay allowed exiting to a destination"},
+		"exits-reaching": {runExitsReaching, "Exits whose policy allowed reaching a destination"},
+		"versions":       {runVersions, "Number of relays running each Tor version"},
+		"unrecommended":  {runUnrecommended, "Relays which ran an obsolete or unrecommended Tor version"},
+		"contact-search": {runContactSearch, "Full-text search of the relay contacts"},
+	}
+}
+
+func cleanup() {
+	ifPrintln(5, "Starting cleanup()")
+	if g_db != nil {
+		g_db.Close()
+	}
+	ifPrintln(5, "Completed cleanup()")
+}
+
+func main() {
+	defer cleanup()
+
+	args := os.Args[1:]
+	if len(args) == 0 || isHelp(args[0]) {
+		usage()
+		return
+	}
+	if strings.HasPrefix(args[0], "-") { // Legacy form: the import flags, optionally followed by a command
+		runLegacy(args)
+		return
+	}
+	runCommand(args)
+}
+
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// Runs the command named by the first argument. The commands of the former
+// tor-nodes binary are accepted as aliases.
+func runCommand(args []string) {
+	if cmd, ok := commands[args[0]]; ok {
+		cmd.run(args[1:])
+		return
+	}
+	if _, ok := queryCommands[args[0]]; ok { // e.g. "lookup" for "query lookup"
+		runQuery(args)
+		return
+	}
+	switch args[0] {
+	case "import-history":
+		runImportHistory(args[1:])
+	case "parse-contacts": // migrate -parse-contacts, without the migrations
+		if len(args) > 1 {
+			log.Fatal("parse-contacts: takes no arguments")
+		}
+		parseConfigFile(g_cfgFilename, &g_config)
+		reparseContacts()
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown command: "+args[0])
+		usage()
+		os.Exit(2)
+	}
+}
+
+// query command: dispatches to the lookup named by the first argument
+func runQuery(args []string) {
+	if len(args) == 0 || isHelp(args[0]) {
+		printCommands("tor-history query <lookup> [options] ...", queryCommands)
+		return
+	}
+	cmd, ok := queryCommands[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Unknown query: "+args[0])
+		printCommands("tor-history query <lookup> [options] ...", queryCommands)
+		os.Exit(2)
+	}
+	cmd.run(args[1:])
+}
+
+func usage() {
+	printCommands("tor-history <command> [options] ...", commands)
+	fmt.Fprintln(os.Stderr, "\nRun \"tor-history <command> -h\" for the options of a command.")
+	fmt.Fprintln(os.Stderr, "The import options are also accepted without a command: \"tor-history [import options]\".")
+	fmt.Fprintln(os.Stderr, "The query lookups run without \"query\"; \"import-history\" is \"import history\" and")
+	fmt.Fprintln(os.Stderr, "\"parse-contacts\" re-parses the contacts, as \"migrate -parse-contacts\" without the migrations.")
+}
+
+func printCommands(synopsis string, cmds map[string]command) {
+	fmt.Fprintf(os.Stderr, "Usage: %s\n\nCommands:\n", synopsis)
+	var names []string
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, cmds[name].synopsis)
+	}
+}
+
+// New flag set of a command with the shared flags and a usage message
+func newFlagSet(name string, synopsis string, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&g_cfgFilename, "config-filename", g_cfgFilename, "Full path of YAML config file")
+	fs.UintVar(&g_verbosity, "verbosity", g_verbosity, "Verbosity level. If negative print to Stderr")
+	fs.BoolVar(&g_quiet, "quiet", g_quiet, "Suppreses all verbocity")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n\n%s.\n\nOptions:\n", strings.TrimSpace("tor-history "+name), synopsis, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parses the command line of a command, loads the configuration and opens the database
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	parseConfigFile(g_cfgFilename, &g_config)
+	openDB()
+}
+
+// Names of the flags set on the command line
+func flagsSet(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// Loads the configuration file, if any, over the shared command line flags and
+// validates the database configuration
+func parseConfigFile(filename string, cfg *config.TorHistoryConfig) {
+	cfg.Verbosity = g_verbosity
+	cfg.Quiet = g_quiet
+	logging.Verbosity, logging.Quiet = cfg.Verbosity, cfg.Quiet
+
+	if filename != "" { // Read config file if one supplied
+		config.ParseFile(filename, cfg)
+		logging.Verbosity = cfg.Verbosity
+	}
+
+	if cfg.Tor.ConsensusURL == "" {
+		ifPrintln(-1, "Adding default consensus URL")
+		cfg.Tor.ConsensusURL = config.ConsensusDetailsURL
+	}
+
+	// Validate DB arguments
+	if cfg.DBServer.Host != "" && cfg.DBServer.Port != "" && cfg.DBServer.DBName != "" && cfg.DBServer.Username != "" {
+		cfg.DBServer.Enabled = true
+	} else if cfg.DBServer.Host != "" || cfg.DBServer.Port != "" || cfg.DBServer.DBName != "" || cfg.DBServer.Username != "" || cfg.DBServer.Password != "" {
+		log.Fatal("Incomplete database configuation.\n" + config.FmtDB(*cfg, true) + "\n")
+	}
+}
+
+// Opens the database if the backend is enabled and it is not open yet
+func openDB() {
+	if g_config.DBServer.Enabled && g_db == nil {
+		g_db = store.NewDBFromConfig(g_config)
+	}
+}
+
+// Legacy command line of tor-nodes: the import flags, optionally followed by a
+// command which then runs with the configuration they loaded
+func runLegacy(args []string) {
+	fs := newFlagSet("", "[import options] [command ...]",
+		"Imports the consensus; the import options may also precede a command")
+	imp := addImportFlags(fs)
+	prt := addPrintFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		// Only the shared flags apply to the command; the others would be silently dropped
+		for name := range flagsSet(fs) {
+			if name != "config-filename" && name != "verbosity" && name != "quiet" {
+				log.Fatalf("-%s is an import or print option: it cannot precede the command %q", name, fs.Arg(0))
+			}
+		}
+		runCommand(fs.Args())
+		return
+	}
+	parseConfigFile(g_cfgFilename, &g_config)
+	importConsensus(imp, prt)
+}
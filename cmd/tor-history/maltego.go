@@ -0,0 +1,180 @@
+//go:build maltego
+// +build maltego
+
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/logging"
+	"github.com/Harsh-bartariya/tor-history/query"
+	"github.com/Harsh-bartariya/tor-history/store"
+	"github.com/sensepost/maltegolocal/maltegolocal"
+)
+
+// maltego command: the Maltego local transform. Its settings are the command line
+// parameters of the local transform, ended by "--". The entity value and properties
+// added by Maltego follow and are not parsed as flags: a phrase or a nickname may
+// start with "-".
+func runMaltego(args []string) {
+	var settings []string
+	for i, arg := range args {
+		if arg == "--" {
+			settings, args = args[:i], args[i+1:]
+			break
+		}
+	}
+	fs := newFlagSet("maltego", "[-config-filename fn] [-from t] [-to t | -at t] -- value [properties]",
+		"Runs the Maltego local transform on the entity value and properties added by Maltego")
+	fs.Init("maltego", flag.ContinueOnError)
+	tr := addTimeRangeFlags(fs)
+	err := fs.Parse(settings)
+	if err == nil && fs.NArg() > 0 {
+		err = fmt.Errorf("unexpected transform settings: %v", fs.Args())
+	}
+
+	lt := maltegolocal.ParseLocalArguments(append([]string{os.Args[0]}, args...))
+	EntityValue := lt.Value
+	TRX := maltegolocal.MaltegoTransform{}
+
+	from, to := "", ""
+	if err == nil {
+		from, to, err = store.ParseTimeRange(*tr.from, *tr.to, *tr.at)
+	}
+	if err == nil {
+		parseConfigFile(g_cfgFilename, &g_config)
+		if !g_config.DBServer.Enabled {
+			err = errors.New("maltego: requires a database configuration (-config-filename)")
+		}
+	}
+	if err != nil {
+		TRX.AddUIMessage(err.Error(), "FatalError")
+		fmt.Println(TRX.ReturnOutput())
+		return
+	}
+	logging.Quiet = true // The transform output goes to stdout
+	openDB()
+	q := query.New(g_db)
+
+	for k, v := range lt.Values {
+		switch k {
+		case "countrysc": // Maltego typ country field
+			EntityValue = strings.ToLower(v)
+			addRelays(&TRX, q.ByCountryCode(EntityValue, from, to))
+		case "properties.shodan.country": // Shodan type country field
+			EntityValue = strings.ToLower(EntityValue)
+			addRelays(&TRX, q.ByCountryCode(EntityValue, from, to))
+		case "ipv4-address", "ipv6-address":
+			addRelays(&TRX, q.ByIP(EntityValue, from, to))
+		case "ipv4-range": // Maltego netblock: CIDR or first-last
+			addRelays(&TRX, lookupNetblock(q, EntityValue, from, to))
+		case "as.number": // Maltego AS entity
+			addASIntervals(&TRX, q.ByAS(v, from, to))
+		case "Fingerprint": // ktt.TORNode: every recorded version of the relay
+			addTimeline(&TRX, q.Timeline(v))
+		case "text": // Maltego phrase: nickname stem, or a glob if it has wildcards
+			mode := "prefix"
+			if strings.ContainsAny(v, "*?") {
+				mode = "glob"
+			}
+			relays, err := q.ByNickname(v, mode, 0, from, to)
+			if err != nil {
+				TRX.AddUIMessage(err.Error(), "PartialError")
+			}
+			addRelays(&TRX, relays)
+		case "email":
+			addRelays(&TRX, q.ByEmail(EntityValue, from, to))
+		case "fqdn": // Maltego DNS name and domain entities
+			addRelays(&TRX, q.ByHostName(EntityValue, from, to))
+		}
+	}
+	TRX.AddUIMessage("completed!", "Inform")
+	fmt.Println(TRX.ReturnOutput())
+}
+
+func addRelays(TRX *maltegolocal.MaltegoTransform, relays map[string](map[string]string)) {
+	TRX.AddUIMessage(fmt.Sprintf("Records matching: %d\n", len(relays)), "Inform")
+	for _, relay := range relays {
+		createMaltegoNode(TRX, relay)
+	}
+}
+
+// Relays which used an address of the netblock in the [from, to] time range
+func lookupNetblock(q *query.Client, netblock string, from string, to string) map[string](map[string]string) {
+	var matches []query.AddressMatch
+	if strings.Contains(netblock, "/") {
+		matches = q.ByCIDR(netblock, "", from, to)
+	} else if bounds := strings.SplitN(netblock, "-", 2); len(bounds) == 2 {
+		matches = q.ByIPRange(bounds[0], bounds[1], "", from, to)
+	}
+	return query.LatestRelays(matches)
+}
+
+// One node per relay, with the intervals it spent in the AS
+func addASIntervals(TRX *maltegolocal.MaltegoTransform, intervals []query.ASInterval) {
+	relays := query.LatestASRelays(intervals)
+	TRX.AddUIMessage(fmt.Sprintf("Records matching: %d\n", len(relays)), "Inform")
+	for fp, relay := range relays {
+		BaseEnt := createMaltegoNode(TRX, relay)
+		for _, i := range intervals {
+			if i.Fingerprint == fp {
+				BaseEnt.AddProperty("AS_intervals", "AS Interval", "nostrict", i.AS+" "+i.ASName+" ("+i.From+" - "+i.To+")")
+			}
+		}
+	}
+}
+
+func addTimeline(TRX *maltegolocal.MaltegoTransform, timeline []query.TimelineEntry) {
+	TRX.AddUIMessage(fmt.Sprintf("Versions found: %d\n", len(timeline)), "Inform")
+	for _, e := range timeline {
+		r := e.Relay
+		BaseEnt := TRX.AddEntity("ktt.TORNode", r.Nickname+"\n"+r.Fingerprint+"\n"+e.From+" - "+e.To)
+		BaseEnt.AddProperty("Nickname", "", "nostrict", r.Nickname)
+		BaseEnt.AddProperty("Fingerprint", "", "nostrict", r.Fingerprint)
+		BaseEnt.AddProperty("RecordTimeInserted", "", "nostrict", e.From)
+		BaseEnt.AddProperty("RecordLastSeen", "", "nostrict", e.To)
+		BaseEnt.AddProperty("Country", "", "nostrict", r.Country)
+		BaseEnt.AddProperty("PlatformName", "", "nostrict", r.Platform)
+		BaseEnt.AddProperty("VersionName", "", "nostrict", r.Version)
+		BaseEnt.AddProperty("ContactName", "", "nostrict", r.Contact)
+		for _, a := range e.Addresses {
+			BaseEnt.AddProperty(a.Role+"_addresses", "", "nostrict", a.Address+" ("+a.First_seen+" - "+a.Last_seen+")")
+		}
+	}
+}
+
+func createMaltegoNode(TRX *maltegolocal.MaltegoTransform, relay map[string]string) *maltegolocal.MaltegoEntityObj {
+	BaseEnt := TRX.AddEntity("ktt.TORNode", relay["Nickname"]+"\n"+relay["Fingerprint"])
+	for k, v := range relay {
+		if k == "ID" {
+			continue
+		}
+		BaseEnt.AddProperty(k, "", "nostrict", v)
+	}
+	// Dynamic properties
+	details, err := query.Details(relay)
+	if err != nil {
+		TRX.AddUIMessage(fmt.Sprintf("Problem unmarshalling: %s\n %s", relay["jsd"], err), "Inform")
+	}
+	for _, ip := range details.Exit_addresses {
+		BaseEnt.AddProperty("Exit_addresses", "Exit Address", "nostrict", ip)
+	}
+	for _, ip := range details.Or_addresses {
+		BaseEnt.AddProperty("Or_addresses", "Router Address", "nostrict", ip)
+	}
+	if len(details.Dir_address) > 0 {
+		BaseEnt.AddProperty("Dir_addresses", "Directory Address", "nostrict", details.Dir_address)
+	}
+	return BaseEnt
+}
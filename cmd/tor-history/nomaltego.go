@@ -0,0 +1,20 @@
+//go:build !maltego
+// +build !maltego
+
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package main
+
+import "log"
+
+// maltego command of a binary built without the transform: github.com/sensepost/maltegolocal
+// is not a requirement of the module
+func runMaltego(args []string) {
+	log.Fatal("maltego: the transform is not built in. Build it with: " +
+		"go get github.com/sensepost/maltegolocal && go build -tags maltego ./cmd/tor-history")
+}
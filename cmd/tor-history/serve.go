@@ -0,0 +1,177 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Harsh-bartariya/tor-history/query"
+	"github.com/Harsh-bartariya/tor-history/store"
+)
+
+// An API endpoint: the path arguments after its prefix, and the [from, to] time range
+// of the from, to and at URL parameters
+type apiHandler func(r *http.Request, args []string, from string, to string) (interface{}, error)
+
+// serve command
+func runServe(args []string) {
+	fs := newFlagSet("serve", "[-listen addr]",
+		"Serves the lookups as a read-only JSON HTTP API, e.g. GET /lookup/cc/de?from=2019-03-01&to=2019-03-31")
+	listen := fs.String("listen", "localhost:8080", "Address to listen on")
+	parseFlags(fs, args)
+
+	if !g_db.Initialized() {
+		log.Fatal("serve: requires a database configuration (-config-filename)")
+	}
+
+	q := query.New(g_db)
+	mux := http.NewServeMux()
+	var mu sync.Mutex // The store is not safe for concurrent use
+	handle := func(prefix string, nargs int, h apiHandler) {
+		mux.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			serveAPI(w, r, prefix, nargs, h)
+		})
+	}
+
+	handle("/lookup/", 2, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		return lookupRelays(q, args[0], args[1], from, to)
+	})
+	handle("/ip/", 1, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		port := r.URL.Query().Get("port")
+		if strings.Contains(args[0], "/") {
+			return q.ByCIDR(args[0], port, from, to), nil
+		}
+		return q.ByIPAt(args[0], port, from, to), nil
+	})
+	handle("/as/", 1, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		return q.ByAS(args[0], from, to), nil
+	})
+	handle("/nickname/", 1, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		mode, distance := r.URL.Query().Get("mode"), 2
+		if mode == "" {
+			mode = "exact"
+		}
+		if d := r.URL.Query().Get("distance"); d != "" {
+			var err error
+			if distance, err = strconv.Atoi(d); err != nil {
+				return nil, errors.New("invalid distance: " + d)
+			}
+		}
+		return q.ByNickname(args[0], mode, distance, from, to)
+	})
+	handle("/timeline/", 1, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		return q.Timeline(args[0]), nil
+	})
+	handle("/relay-changes/", 1, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		return q.Changes(args[0]), nil
+	})
+	handle("/exit-policy/", 3, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		port, err := strconv.ParseUint(args[2], 10, 16)
+		if err != nil {
+			return nil, errors.New("invalid port: " + args[2])
+		}
+		at, err := atParam(r)
+		if err != nil {
+			return nil, err
+		}
+		allowed, found, reason, err := q.ExitAllowed(args[0], at, args[1], uint16(port))
+		return map[string]interface{}{"Allowed": allowed, "Found": found, "Reason": reason}, err
+	})
+	handle("/exits-reaching/", 2, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		port, err := strconv.ParseUint(args[1], 10, 16)
+		if err != nil {
+			return nil, errors.New("invalid port: " + args[1])
+		}
+		at, err := atParam(r)
+		if err != nil {
+			return nil, err
+		}
+		return q.ExitsReaching(args[0], uint16(port), at)
+	})
+	handle("/versions", 0, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		at, err := atParam(r)
+		if err != nil {
+			return nil, err
+		}
+		return q.VersionDistribution(at), nil
+	})
+	handle("/unrecommended", 0, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		return q.ByUnrecommendedVersion(from, to), nil
+	})
+	handle("/contact-search", 0, func(r *http.Request, args []string, from string, to string) (interface{}, error) {
+		text := r.URL.Query().Get("q")
+		if _, ftQuery := store.ContactSearchQuery(text); ftQuery == "" {
+			return nil, errors.New("no searchable words (at least 3 characters, not a stopword) in q")
+		}
+		return q.SearchContacts(text, from, to), nil
+	})
+
+	ifPrintln(-1, "Listening on http://"+*listen)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
+
+// Parses the path arguments and the time range of the request, runs the handler and
+// writes its result as JSON. The last argument takes the rest of the path, so that it
+// may hold a CIDR block.
+func serveAPI(w http.ResponseWriter, r *http.Request, prefix string, nargs int, h apiHandler) {
+	ifPrintln(2, "serve: "+r.Method+" "+r.URL.String())
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	var args []string
+	if nargs > 0 {
+		args = strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", nargs)
+		if len(args) != nargs || args[nargs-1] == "" {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("%d path arguments are required after %s", nargs, prefix))
+			return
+		}
+	} else if r.URL.Path != prefix {
+		writeAPIError(w, http.StatusNotFound, "not found: "+r.URL.Path)
+		return
+	}
+
+	params := r.URL.Query()
+	from, to, err := store.ParseTimeRange(params.Get("from"), params.Get("to"), params.Get("at"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := h(r, args, from, to)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		ifPrintln(-1, "serve: "+err.Error())
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// The at URL parameter of the point in time endpoints, now by default
+func atParam(r *http.Request) (string, error) {
+	return store.ParseTime(r.URL.Query().Get("at"), time.Now().UTC().Format(store.TimeFmt))
+}
@@ -0,0 +1,113 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+// Package config holds the tor-history configuration, read from the YAML
+// configuration file and the command line.
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Harsh-bartariya/tor-history/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// Default Onionoo details document
+const ConsensusDetailsURL = "https://onionoo.torproject.org/details"
+
+type TorHistoryConfig struct {
+	Verbosity uint `yaml:"verbosity"`
+	Quiet     bool // Overrides and level of verbosity; cannot be configured in config file
+
+	DBServer struct {
+		Enabled      bool   //`yaml:"enabled"`
+		Port         string `yaml:"port"`
+		Host         string `yaml:"host"`
+		DBName       string `yaml:"database"`
+		Username     string `yaml:"username"`
+		Password     string `yaml:"password"`
+		ReInitCaches int    `yaml:"reinit-caches"`
+	} `yaml:"dbserver"`
+	Tor struct {
+		ConsensusURL     string `yaml:"url"`      // Consensus URL
+		Filename         string `yaml:"Filename"` // Input filename
+		ConsensusDLT     string
+		ConsensusDLT_fmt string
+
+		ExtractDLTfromFilename       bool
+		ExtractDLTfromFilename_regex string
+	} `yaml:"consensus"`
+	Backup struct {
+		Filename string `yaml:"filename"`
+		Gzip     bool   `yaml:"gzip"`
+	} `yaml:"backup"`
+	Retention struct {
+		MetricsMonths    int  `yaml:"metrics-months"`
+		DownsampleMonths int  `yaml:"downsample-months"`
+		GC               bool `yaml:"gc"`
+	} `yaml:"retention"`
+	Print struct {
+		Output         string   // Output format: "text" (Separator joined fields) or one of output.Formats
+		Fields         []string // Printed relay fields (paths, see onionoo.FieldValue) instead of the options below
+		Template       string   // text/template printing each relay, instead of Output
+		Separator      string
+		Nickname       bool
+		Fingerprint    bool
+		Or_addresses   bool
+		Exit_addresses bool
+		Dir_address    bool
+		Country        bool
+		AS             bool
+		Hostname       bool
+		Flags          bool
+		IPperLine      bool
+	} `yaml:"Print"`
+	Filter struct {
+		Running     bool
+		Hibernating bool
+		MatchFlags  []string
+	}
+}
+
+// Reads the YAML configuration file into cfg. A verbosity set on the command line is preserved.
+func ParseFile(cfgFilename string, cfg *TorHistoryConfig) {
+	logging.IfPrintln(-1, "Reading configuration file: "+cfgFilename)
+	if cfgFilename == "" {
+		return
+	}
+	f, err := os.Open(cfgFilename)
+	if err != nil {
+		log.Fatalf("Unable to open configuration file: %s\n", cfgFilename)
+	}
+	defer f.Close()
+
+	cmdLineVerbosity := cfg.Verbosity // Preserve verbosity from the command line (if 0 - not set)
+	// after the config file is read, it will overwrite the global verbosity variable which may have been set by a command line argument
+	decoder := yaml.NewDecoder(f)
+	err = decoder.Decode(cfg)
+	if err != nil {
+		log.Fatalf("YAML Decoder error: %s\n", err)
+	}
+	if cmdLineVerbosity != 0 { // Restore verbosity level set by command line (if it was set)
+		cfg.Verbosity = cmdLineVerbosity
+	}
+	logging.IfPrintln(-8, FmtDB(*cfg, true))
+}
+
+// Formats the database configuration for logging
+func FmtDB(cfg TorHistoryConfig, hidePassword bool) string {
+	var pwd string
+	if hidePassword {
+		pwd = "<redacted>"
+	} else {
+		pwd = cfg.DBServer.Password
+	}
+	return fmt.Sprintf("Database configutation:  Host: %s\n  Port: %s\n  DB Name: %s\n  Username: %s\n  Password: %s",
+		cfg.DBServer.Host, cfg.DBServer.Port, cfg.DBServer.DBName, cfg.DBServer.Username, pwd)
+}
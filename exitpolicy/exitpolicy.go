@@ -0,0 +1,320 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+// Package exitpolicy parses Tor exit policies, the full rules from the relay
+// descriptors as well as the Onionoo port summaries, and evaluates them.
+package exitpolicy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+)
+
+type PortRange struct {
+	Lo, Hi uint16
+}
+
+func (r PortRange) Contains(port uint16) bool {
+	return r.Lo <= port && port <= r.Hi
+}
+
+func (r PortRange) String() string {
+	if r.Lo == 1 && r.Hi == 65535 {
+		return "*"
+	}
+	if r.Lo == r.Hi {
+		return strconv.Itoa(int(r.Lo))
+	}
+	return fmt.Sprintf("%d-%d", r.Lo, r.Hi)
+}
+
+// One exit policy line: (accept|reject)[6] addrspec:portspec
+type Rule struct {
+	Accept   bool
+	Family   int        // 0 any, 4 or 6
+	Network  *net.IPNet // nil matches every address of the family
+	Ports    PortRange
+	Original string
+}
+
+// Rules in order; the first matching rule decides
+type Policy []Rule
+
+// Onionoo exit policy summary: the ports accepted (or rejected) for most addresses
+type Summary struct {
+	Accept bool
+	Ports  []PortRange
+}
+
+// Parses a port ("80"), a range ("6660-6669") or "*"
+func ParsePortRange(s string) (PortRange, error) {
+	if s == "*" {
+		return PortRange{1, 65535}, nil
+	}
+	lo, hi := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	l, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return PortRange{}, errors.New("invalid port: " + s)
+	}
+	h, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil || h < l {
+		return PortRange{}, errors.New("invalid port range: " + s)
+	}
+	return PortRange{uint16(l), uint16(h)}, nil
+}
+
+// Parses an address spec: "*", "*4", "*6", an address, or an address with a
+// "/bits" or dotted "/255.255.0.0" mask. IPv6 addresses are in brackets.
+func parseAddrSpec(s string, rule *Rule) error {
+	switch s {
+	case "*":
+		return nil
+	case "*4":
+		rule.Family = 4
+		return nil
+	case "*6":
+		rule.Family = 6
+		return nil
+	}
+
+	addr, mask := s, ""
+	if i := strings.Index(s, "/"); i >= 0 {
+		addr, mask = s[:i], s[i+1:]
+	}
+	v6 := strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]")
+	if v6 {
+		addr = addr[1 : len(addr)-1]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || (ip.To4() == nil) != v6 {
+		return errors.New("invalid address: " + s)
+	}
+
+	bits := 32
+	if v6 {
+		bits = 128
+		rule.Family = 6
+	} else {
+		ip = ip.To4()
+		rule.Family = 4
+	}
+	ones := bits
+	if mask != "" {
+		if n, err := strconv.Atoi(mask); err == nil {
+			ones = n
+		} else if m := net.ParseIP(mask); m != nil && !v6 && m.To4() != nil {
+			ones, bits = net.IPMask(m.To4()).Size()
+			if bits == 0 {
+				return errors.New("invalid mask: " + s)
+			}
+		} else {
+			return errors.New("invalid mask: " + s)
+		}
+		if ones < 0 || ones > bits {
+			return errors.New("invalid mask: " + s)
+		}
+	}
+	ipMask := net.CIDRMask(ones, bits)
+	rule.Network = &net.IPNet{IP: ip.Mask(ipMask), Mask: ipMask}
+	return nil
+}
+
+// Parses one exit policy line, e.g. "reject 10.0.0.0/8:*", "accept *:80" or "accept6 [2001:db8::]/32:443"
+func ParseRule(line string) (Rule, error) {
+	rule := Rule{Original: strings.TrimSpace(line)}
+	fields := strings.Fields(rule.Original)
+	if len(fields) != 2 {
+		return rule, errors.New("invalid exit policy rule: " + line)
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "accept":
+		rule.Accept = true
+	case "reject":
+	case "accept6":
+		rule.Accept = true
+		rule.Family = 6
+	case "reject6":
+		rule.Family = 6
+	default:
+		return rule, errors.New("invalid exit policy action: " + line)
+	}
+	family := rule.Family
+
+	spec := fields[1]
+	i := strings.LastIndex(spec, ":")
+	if i < 0 || i < strings.LastIndex(spec, "]") {
+		return rule, errors.New("missing port in exit policy rule: " + line)
+	}
+	var err error
+	if rule.Ports, err = ParsePortRange(spec[i+1:]); err != nil {
+		return rule, err
+	}
+	if err = parseAddrSpec(spec[:i], &rule); err != nil {
+		return rule, err
+	}
+	if family == 6 && rule.Family == 4 {
+		return rule, errors.New("IPv4 address in an IPv6 rule: " + line)
+	}
+	return rule, nil
+}
+
+// Parses the exit policy lines of a relay (Onionoo Exit_policy)
+func Parse(lines []string) (Policy, error) {
+	var policy Policy
+	for _, line := range lines {
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		policy = append(policy, rule)
+	}
+	return policy, nil
+}
+
+// Whether the rule applies to the destination
+func (r Rule) Matches(ip net.IP, port uint16) bool {
+	v4 := ip.To4() != nil
+	if (r.Family == 4 && !v4) || (r.Family == 6 && v4) {
+		return false
+	}
+	if r.Network != nil && !r.Network.Contains(ip) {
+		return false
+	}
+	return r.Ports.Contains(port)
+}
+
+// Whether the policy accepts the destination and the deciding rule (nil if no rule
+// matched). As in Tor, a destination no rule matches is accepted; relay policies end
+// with a catch-all rule anyway.
+func (p Policy) Evaluate(ip net.IP, port uint16) (bool, *Rule) {
+	for i := range p {
+		if p[i].Matches(ip, port) {
+			return p[i].Accept, &p[i]
+		}
+	}
+	return true, nil
+}
+
+// Parses an Onionoo summary, {"accept": ["80", "443", "6660-6669"]} or {"reject": [...]},
+// as decoded into an interface{} (Exit_policy_summary, Exit_policy_v6_summary)
+func ParseSummary(v interface{}) (*Summary, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSummaryJSON(js)
+}
+
+// Parses an Onionoo summary as stored in ExitPolicySummaries. Returns nil for an empty summary.
+func ParseSummaryJSON(js []byte) (*Summary, error) {
+	var m map[string][]string
+	if err := json.Unmarshal(js, &m); err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	if len(m) != 1 {
+		return nil, errors.New("exit policy summary has more than one element: " + string(js))
+	}
+
+	var s Summary
+	var ports []string
+	if ports = m["accept"]; ports != nil {
+		s.Accept = true
+	} else if ports = m["reject"]; ports == nil {
+		return nil, errors.New("exit policy summary needs an accept or reject element: " + string(js))
+	}
+	for _, p := range ports {
+		r, err := ParsePortRange(p)
+		if err != nil {
+			return nil, err
+		}
+		s.Ports = append(s.Ports, r)
+	}
+	return &s, nil
+}
+
+// Whether the summary accepts the port
+func (s *Summary) Allows(port uint16) bool {
+	for _, r := range s.Ports {
+		if r.Contains(port) {
+			return s.Accept
+		}
+	}
+	return !s.Accept
+}
+
+// Exit policy of a relay as published by Onionoo: the descriptor rules and the summaries
+type RelayPolicy struct {
+	Rules     Policy
+	Summary   *Summary
+	SummaryV6 *Summary // nil if the relay rejects all IPv6 exiting
+}
+
+func NewRelayPolicy(relay onionoo.TorRelayDetails) (*RelayPolicy, error) {
+	var rp RelayPolicy
+	var err error
+	if rp.Rules, err = Parse(relay.Exit_policy); err != nil {
+		return nil, err
+	}
+	if relay.Exit_policy_summary != nil {
+		if rp.Summary, err = ParseSummary(relay.Exit_policy_summary); err != nil {
+			return nil, err
+		}
+	}
+	if relay.Exit_policy_v6_summary != nil {
+		if rp.SummaryV6, err = ParseSummary(relay.Exit_policy_v6_summary); err != nil {
+			return nil, err
+		}
+	}
+	return &rp, nil
+}
+
+// Whether the policy allowed exiting to ip:port and a description of the deciding rule.
+// IPv4 destinations are evaluated with the full rules when available, else with the
+// summary. The descriptor rules only cover IPv4; IPv6 destinations use the IPv6 summary.
+func (rp *RelayPolicy) Allows(ip net.IP, port uint16) (bool, string) {
+	if ip.To4() != nil {
+		if len(rp.Rules) > 0 {
+			allowed, rule := rp.Rules.Evaluate(ip, port)
+			if rule == nil {
+				return allowed, "no matching rule"
+			}
+			return allowed, rule.Original
+		}
+		return summaryAllows(rp.Summary, port, "exit policy summary")
+	}
+	return summaryAllows(rp.SummaryV6, port, "IPv6 exit policy summary")
+}
+
+func summaryAllows(s *Summary, port uint16, name string) (bool, string) {
+	if s == nil {
+		return false, "no " + name
+	}
+	return s.Allows(port), name
+}
+
+// Whether the relay allowed exiting to ip:port, see RelayPolicy.Allows
+func RelayAllows(relay onionoo.TorRelayDetails, ip net.IP, port uint16) (allowed bool, reason string, err error) {
+	rp, err := NewRelayPolicy(relay)
+	if err != nil {
+		return false, "", err
+	}
+	allowed, reason = rp.Allows(ip, port)
+	return allowed, reason, nil
+}
@@ -0,0 +1,189 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package exitpolicy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+)
+
+func TestParseRule(t *testing.T) {
+	for line, want := range map[string]struct {
+		accept  bool
+		family  int
+		network string
+		ports   string
+	}{
+		"accept *:80":                       {true, 0, "", "80"},
+		"reject *:*":                        {false, 0, "", "*"},
+		"reject *4:6660-6669":               {false, 4, "", "6660-6669"},
+		"accept *6:443":                     {true, 6, "", "443"},
+		"reject 10.1.2.3/8:*":               {false, 4, "10.0.0.0/8", "*"},
+		"reject 192.168.1.1/255.255.0.0:25": {false, 4, "192.168.0.0/16", "25"},
+		"accept 128.31.0.34:9101":           {true, 4, "128.31.0.34/32", "9101"},
+		"accept6 [2001:db8::1]/32:443":      {true, 6, "2001:db8::/32", "443"},
+		"reject [::1]:*":                    {false, 6, "::1/128", "*"},
+		"REJECT6 *:22":                      {false, 6, "", "22"},
+	} {
+		r, err := ParseRule(line)
+		if err != nil {
+			t.Errorf("ParseRule(%q): %v", line, err)
+			continue
+		}
+		network := ""
+		if r.Network != nil {
+			network = r.Network.String()
+		}
+		if r.Accept != want.accept || r.Family != want.family || network != want.network || r.Ports.String() != want.ports {
+			t.Errorf("ParseRule(%q) = %v %d %s %s, want %v", line, r.Accept, r.Family, network, r.Ports, want)
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"accept",
+		"accept *:80 extra",
+		"allow *:80",
+		"accept *",
+		"accept [2001:db8::1]",
+		"accept *:0-",
+		"accept *:90-80",
+		"accept *:65536",
+		"accept 10.0.0.256:80",
+		"accept 2001:db8::1:80",
+		"accept [10.0.0.1]:80",
+		"accept 10.0.0.0/33:80",
+		"accept 10.0.0.0/255.0.255.0:80",
+		"accept [2001:db8::]/129:80",
+		"accept6 10.0.0.1:80",
+		"reject6 *4:80",
+	} {
+		if _, err := ParseRule(line); err == nil {
+			t.Errorf("ParseRule(%q) accepted an invalid rule", line)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	policy, err := Parse([]string{
+		"reject 10.0.0.0/8:*",
+		"accept 10.1.0.0/16:80", // Shadowed by the rule above
+		"reject *:25",
+		"accept *4:80-443",
+		"accept6 [2001:db8::]/32:22",
+		"reject *:*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		ip     string
+		port   uint16
+		accept bool
+		rule   string
+	}{
+		{"10.1.2.3", 80, false, "reject 10.0.0.0/8:*"},
+		{"192.0.2.1", 25, false, "reject *:25"},
+		{"192.0.2.1", 80, true, "accept *4:80-443"},
+		{"192.0.2.1", 443, true, "accept *4:80-443"},
+		{"192.0.2.1", 444, false, "reject *:*"},
+		{"2001:db8::1", 80, false, "reject *:*"},
+		{"2001:db8::1", 22, true, "accept6 [2001:db8::]/32:22"},
+		{"2001:db9::1", 22, false, "reject *:*"},
+		{"2001:db8::1", 25, false, "reject *:25"},
+	} {
+		accept, rule := policy.Evaluate(net.ParseIP(tc.ip), tc.port)
+		if rule == nil || accept != tc.accept || rule.Original != tc.rule {
+			t.Errorf("Evaluate(%s:%d) = %v, %v; want %v, %s", tc.ip, tc.port, accept, rule, tc.accept, tc.rule)
+		}
+	}
+
+	if accept, rule := (Policy{}).Evaluate(net.ParseIP("192.0.2.1"), 80); !accept || rule != nil {
+		t.Errorf("an empty policy: %v, %v; want it accepted without a rule", accept, rule)
+	}
+}
+
+func TestSummaryAllows(t *testing.T) {
+	for _, tc := range []struct {
+		summary string
+		port    uint16
+		allowed bool
+	}{
+		{`{"accept": ["80", "443", "6660-6669"]}`, 80, true},
+		{`{"accept": ["80", "443", "6660-6669"]}`, 6665, true},
+		{`{"accept": ["80", "443", "6660-6669"]}`, 22, false},
+		{`{"reject": ["25", "119", "135-139"]}`, 25, false},
+		{`{"reject": ["25", "119", "135-139"]}`, 137, false},
+		{`{"reject": ["25", "119", "135-139"]}`, 80, true},
+		{`{"reject": ["1-65535"]}`, 443, false},
+	} {
+		s, err := ParseSummaryJSON([]byte(tc.summary))
+		if err != nil {
+			t.Errorf("ParseSummaryJSON(%s): %v", tc.summary, err)
+			continue
+		}
+		if got := s.Allows(tc.port); got != tc.allowed {
+			t.Errorf("%s allows %d: %v, want %v", tc.summary, tc.port, got, tc.allowed)
+		}
+	}
+
+	for _, js := range []string{`{"accept": ["80"], "reject": ["25"]}`, `{"other": ["80"]}`, `{"accept": ["x"]}`, `[]`} {
+		if _, err := ParseSummaryJSON([]byte(js)); err == nil {
+			t.Errorf("ParseSummaryJSON(%s) accepted an invalid summary", js)
+		}
+	}
+	if s, err := ParseSummaryJSON([]byte(`{}`)); s != nil || err != nil {
+		t.Errorf("empty summary: %v, %v; want nil", s, err)
+	}
+}
+
+// The descriptor rules only cover IPv4: IPv6 destinations go through the IPv6 summary
+func TestRelayAllows(t *testing.T) {
+	relay := onionoo.TorRelayDetails{
+		Exit_policy:            []string{"reject *:25", "accept *:*"},
+		Exit_policy_summary:    map[string]interface{}{"reject": []interface{}{"25", "80"}},
+		Exit_policy_v6_summary: map[string]interface{}{"accept": []interface{}{"443"}},
+	}
+	summaryOnly := relay
+	summaryOnly.Exit_policy = nil
+	noV6 := relay
+	noV6.Exit_policy_v6_summary = nil
+
+	for _, tc := range []struct {
+		name    string
+		relay   onionoo.TorRelayDetails
+		ip      string
+		port    uint16
+		allowed bool
+		reason  string
+	}{
+		{"rules", relay, "192.0.2.1", 80, true, "accept *:*"},
+		{"rules", relay, "192.0.2.1", 25, false, "reject *:25"},
+		{"rules", relay, "2001:db8::1", 80, false, "IPv6 exit policy summary"},
+		{"rules", relay, "2001:db8::1", 443, true, "IPv6 exit policy summary"},
+		{"summary", summaryOnly, "192.0.2.1", 80, false, "exit policy summary"},
+		{"summary", summaryOnly, "192.0.2.1", 443, true, "exit policy summary"},
+		{"no IPv6", noV6, "2001:db8::1", 443, false, "no IPv6 exit policy summary"},
+		{"no IPv6", noV6, "::ffff:192.0.2.1", 443, true, "accept *:*"}, // IPv4-mapped
+	} {
+		allowed, reason, err := RelayAllows(tc.relay, net.ParseIP(tc.ip), tc.port)
+		if err != nil || allowed != tc.allowed || reason != tc.reason {
+			t.Errorf("%s: RelayAllows(%s:%d) = %v, %q, %v; want %v, %q", tc.name, tc.ip, tc.port, allowed, reason, err, tc.allowed, tc.reason)
+		}
+	}
+
+	relay.Exit_policy = []string{"accept *:x"}
+	if _, _, err := RelayAllows(relay, net.ParseIP("192.0.2.1"), 80); err == nil {
+		t.Error("RelayAllows accepted an invalid policy")
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/Harsh-bartariya/tor-history
+
+go 1.13
+
+require (
+	github.com/go-sql-driver/mysql v1.7.1
+	gopkg.in/yaml.v2 v2.4.0
+)
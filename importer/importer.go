@@ -0,0 +1,835 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+// Package importer loads Onionoo details documents into the store.
+package importer
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Harsh-bartariya/tor-history/config"
+	"github.com/Harsh-bartariya/tor-history/logging"
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+	"github.com/Harsh-bartariya/tor-history/output"
+	"github.com/Harsh-bartariya/tor-history/store"
+)
+
+var ifPrintln = logging.IfPrintln
+
+// Imports consensus snapshots. DB is nil when the database backend is disabled,
+// in which case the relays are only printed.
+type Importer struct {
+	DB     *store.DB
+	Config *config.TorHistoryConfig
+	DLTS   string // Consensus download timestamp of the snapshot being imported (YYYYMMDDhhmmss)
+
+	out  *output.Formatter  // Relay printing in a Print.Output format other than text, once started
+	tmpl *template.Template // Relay printing with the Print.Template, once started
+}
+
+func New(db *store.DB, cfg *config.TorHistoryConfig) *Importer {
+	return &Importer{DB: db, Config: cfg}
+}
+
+// Imports the configured consensus: downloaded from Tor.ConsensusURL or read from
+// the file(s) matching the Tor.Filename pattern
+func (imp *Importer) Import() {
+	imp.startOutput()
+	defer imp.flushOutput()
+
+	// Acquire the Consensus download time. If importing from a file, it is
+	// taken from the command line or the filename itself. If downloaded it's now()
+	if imp.Config.Tor.Filename == "" {
+		// Set Consensus download time. For downloads it is the sytem time (now())
+		imp.DLTS = imp.getConsensusDLTimestamp("")
+		imp.initializeCaches()
+
+		tor_response := imp.getConsensus(true, imp.Config.Tor.ConsensusURL)
+		imp.logDataImport(&tor_response)
+		imp.recordRelayPresence(&tor_response)
+		imp.processTorResponse(&tor_response)
+	} else {
+		filenames, err := filepath.Glob(imp.Config.Tor.Filename)
+		if err != nil || len(filenames) == 0 {
+			log.Fatal("Bad filename pattern: ", imp.Config.Tor.Filename)
+		}
+
+		var bench_bulk time.Time
+		total_files := len(filenames)
+		if total_files > 1 {
+			bench_bulk = time.Now()
+			ifPrintln(1, fmt.Sprintf("Bulk import detected (%s). Number of files: %d", imp.Config.Tor.Filename, total_files))
+			if imp.Config.Tor.ConsensusDLT != "" {
+				log.Fatal("Bulk import detected however -consensus-download-time is also specified.")
+			}
+			if !imp.Config.Tor.ExtractDLTfromFilename {
+				ifPrintln(-1, "WARNING: operating in bulk mode without ExtractDLTfromFilename set. Turning it on.")
+				imp.Config.Tor.ExtractDLTfromFilename = true
+			}
+		}
+
+		imp.DLTS = imp.getConsensusDLTimestamp(filenames[0])
+		imp.initializeCaches() // Caches are initialized only for the first file
+
+		var tor_response, previous_tor_response onionoo.TorResponse
+		for num, fn := range filenames {
+			bench_start := time.Now()
+			// Initialize the timestamp for every file
+			imp.DLTS = imp.getConsensusDLTimestamp(filenames[num])
+
+			// Only refresh the caches every imp.Config.DBServer.ReInitCaches times
+			if imp.DB.Initialized() {
+				if (num % imp.Config.DBServer.ReInitCaches) == 0 {
+					imp.initializeCaches()
+				} else {
+					bench_cache := time.Now()
+					imp.DB.InitializeLatestRelayDataCache(&imp.DB.LRD, imp.DLTS)
+					ifPrintln(1, fmt.Sprintf("TorRelay cache reload time: %v", time.Since(bench_cache)))
+				}
+			}
+
+			ifPrintln(1, fmt.Sprintf("Importing sequence: %d/%d; filename: %s.", num, total_files, fn))
+			tor_response = imp.getConsensus(false, fn)
+			// Log the snapshot and the presence of the relays before the unchanged ones are removed
+			imp.logDataImport(&tor_response)
+			imp.recordRelayPresence(&tor_response)
+			if num != 0 { // shortcut
+				old_miss := ExtractNewAndUpdatedRelays(previous_tor_response.Relays, tor_response.Relays)
+				previous_tor_response = tor_response
+				tor_response.Relays = old_miss
+			} else { // num == 0
+				previous_tor_response = tor_response
+			}
+
+			imp.processTorResponse(&tor_response)
+			ifPrintln(1, fmt.Sprintf("Batch added in: %v", time.Since(bench_start)))
+		}
+		if !bench_bulk.IsZero() {
+			ifPrintln(1, fmt.Sprintf("Bulk import of %d files in: %v.", total_files, time.Since(bench_bulk)))
+		}
+	}
+}
+
+func (imp *Importer) getConsensusDLTimestamp(filename string) string { // cmdlineTS string
+	ifPrintln(6, "getConsensusDLTimestamp("+filename+"): ")
+	var t time.Time
+
+	if filename == "" {
+		filename = imp.Config.Tor.Filename
+	}
+
+	if !imp.Config.Tor.ExtractDLTfromFilename && len(imp.Config.Tor.ConsensusDLT) == 0 {
+		ifPrintln(-3, "consensusDownloadTime: using system time")
+		t = time.Now()
+	} else {
+		ifPrintln(-3, "consensusDownloadTime: not using system time, processing command line arguments")
+		// Consensus download time override
+		var ts_matches []string
+		if len(imp.Config.Tor.ConsensusDLT) > 0 {
+			ts_matches = make([]string, 1)
+			ts_matches[0] = imp.Config.Tor.ConsensusDLT
+		}
+		if imp.Config.Tor.ExtractDLTfromFilename {
+			// If RegEx supplied - try it
+			if len(imp.Config.Tor.ExtractDLTfromFilename_regex) > 0 { // If RegEx is provided use it to extract the date
+				re := regexp.MustCompile(imp.Config.Tor.ExtractDLTfromFilename_regex)
+				ts_matches = re.FindAllString(filename, -1)
+			} else { //No regex, try the old way
+				re := regexp.MustCompile(`[0-9][0-9-_:]+[0-9]`)
+				ts_matches = re.FindAllString(filename, -1)
+			}
+			ifPrintln(6, fmt.Sprintf("Extracted timestamp from filename: \n%v", ts_matches))
+		}
+		formats := imp.getTimeFormats()
+		t_res := store.MatchTimestampToFormats(ts_matches, formats)
+		if t_res == nil {
+			log.Fatalln("Unable to parse timestamp.", ts_matches)
+		}
+		t = *t_res
+	} // else
+
+	str := fmt.Sprintf("%04d%02d%02d%02d%02d%02d",
+		t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second())
+	ifPrintln(3, "consensusDownloadTime: returning (DLTS) timestamp: "+str)
+	return str
+}
+
+func (imp *Importer) getTimeFormats() []string {
+	var formats []string
+	// Time format override
+	if len(imp.Config.Tor.ConsensusDLT_fmt) > 0 {
+		ifPrintln(4, "Custom format supplied: "+imp.Config.Tor.ConsensusDLT_fmt)
+		formats = append(formats, imp.Config.Tor.ConsensusDLT_fmt)
+	} else {
+		formats = store.TimeFormats
+	}
+	return formats
+}
+
+func (imp *Importer) initializeCaches() {
+	ifPrintln(2, "Initializing caches...")
+	defer ifPrintln(2, "Caches initialized.")
+
+	if imp.Config.DBServer.Enabled { // Check id DB backend is enabled
+		ifPrintln(2, "Initializing all caches.")
+		defer ifPrintln(2, "All caches initialized.")
+
+		// Initialize DB caches
+		imp.DB.InitCaches(imp.DLTS)
+
+		// Initialize CC cache
+		imp.DB.InitCountryNameCache()
+
+		// Initialize the Latest Relay cache - stores the latest relay before certain timestamp
+		imp.DB.InitializeLatestRelayDataCache(&imp.DB.LRD, imp.DLTS)
+	}
+}
+
+func (imp *Importer) getConsensus(is_url bool, location string) onionoo.TorResponse {
+	var data []byte
+	var err error
+	if is_url {
+		ifPrintln(2, "Downloading Consensus details from: "+location)
+		if data, err = onionoo.Download(location); err != nil {
+			log.Fatal(err)
+		}
+		ifPrintln(2, "Consensus download complete.")
+	} else {
+		ifPrintln(4, "readConsensusDataFromFile(\""+location+"\"): ")
+		if data, err = onionoo.ReadFile(location); err != nil {
+			log.Fatalf("ERROR: reading Consensus data file (%s). ", err.Error())
+		}
+	}
+
+	imp.backupIfRequested(data)
+
+	tor_response, err := onionoo.Parse(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parsing Consensus file: %s", err.Error())
+		log.Fatal(err)
+	}
+
+	return tor_response
+}
+
+func (imp *Importer) backupIfRequested(data []byte) {
+	// Check if backup is requested
+	if imp.Config.Backup.Filename == "" {
+		ifPrintln(-5, "No backup requested.")
+	} else {
+		ifPrintln(-5, "Backup requested.")
+		imp.backupConsensus(data)
+	}
+}
+
+func (imp *Importer) backupConsensus(data []byte) {
+	ifPrintln(2, "backupConsensus: ")
+	defer ifPrintln(2, "backupConsensus complete.")
+
+	SaveConsensus(data, imp.Config.Backup.Filename, imp.Config.Backup.Gzip)
+}
+
+// Saves the consensus as downloaded to prefix-YYYYMMDDhhmmss (UTC), gzipped with a .gz
+// suffix if requested. The name can be parsed back by -extract-consensus-download-time-from-filename.
+// Returns the file name.
+func SaveConsensus(data []byte, prefix string, gz bool) string {
+	t := time.Now().UTC()
+	fn := prefix + "-" + t.Format("20060102150405")
+	if gz {
+		fn += ".gz"
+	}
+
+	ifPrintln(-2, "Creating backup file: "+fn)
+	backup_file, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer backup_file.Close()
+
+	if gz {
+		zw := gzip.NewWriter(backup_file)
+		zw.Name = fn
+		zw.ModTime = time.Now()
+		zw.Comment = "tor-history"
+
+		_, err := zw.Write(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			log.Fatal(err)
+		}
+	} else if _, err := backup_file.Write(data); err != nil {
+		log.Fatal(err)
+	}
+	return fn
+}
+
+// Logs the snapshot along with its network summary. Like recordRelayPresence it
+// must be called with the complete snapshot.
+func (imp *Importer) logDataImport(tor_response *onionoo.TorResponse) {
+	ifPrintln(-3, fmt.Sprintf("TOR Version, build revision: %s, %s (Acquisition time: %s)",
+		tor_response.Version, tor_response.Build_revision, imp.DLTS))
+	if imp.DB.Initialized() {
+		tqid := imp.DB.AddToTorQueries(tor_response.Version, tor_response.Relays_published, tor_response.Bridges_published, imp.DLTS)
+		imp.DB.AddNetworkSummary(tqid, computeNetworkSummary(tor_response))
+	}
+}
+
+// Marks every relay of the snapshot as present at the consensus download time.
+// Must be called with the complete snapshot, not only the new and updated relays.
+// The relays the filter leaves out count too, as long as they already have records:
+// otherwise their intervals would be closed by a filtered import.
+func (imp *Importer) recordRelayPresence(tor_response *onionoo.TorResponse) {
+	if !imp.DB.Initialized() {
+		return
+	}
+	present := make(map[string]bool)
+	for i := range tor_response.Relays {
+		relay := &tor_response.Relays[i]
+		if allStringsInSetMatch(&imp.Config.Filter.MatchFlags, &relay.Flags) { // Same filter as processTorResponse
+			present[imp.DB.Value2ID("fingerprint", relay.Fingerprint)] = true
+		} else if fpid := imp.DB.KnownFingerprintID(relay.Fingerprint); fpid != "" {
+			present[fpid] = true
+		}
+	}
+	imp.DB.UpdatePresenceIntervals(present, imp.DLTS)
+}
+
+// Printed fields, in order: Print.Fields, otherwise the fields selected by the Print options
+func (imp *Importer) printColumns() []string {
+	p := imp.Config.Print
+	if len(p.Fields) > 0 {
+		return p.Fields
+	}
+	var columns []string
+	for _, c := range []struct {
+		selected bool
+		name     string
+	}{
+		{p.Nickname, "nickname"}, {p.Fingerprint, "fingerprint"}, {p.Or_addresses, "or_addresses"},
+		{p.Exit_addresses, "exit_addresses"}, {p.Dir_address, "dir_address"}, {p.Country, "country"},
+		{p.AS, "as"}, {p.Hostname, "host_names"}, {p.Flags, "flags"},
+	} {
+		if c.selected {
+			columns = append(columns, c.name)
+		}
+	}
+	return columns
+}
+
+// Template functions, besides the text/template builtins
+var templateFuncs = template.FuncMap{
+	"join": func(a []string, sep string) string { return strings.Join(a, sep) },
+	"json": func(v interface{}) (string, error) {
+		js, err := json.Marshal(v)
+		return string(js), err
+	},
+	"field": func(relay onionoo.TorRelayDetails, path string) (interface{}, error) { return fieldValue(&relay, path) },
+}
+
+// Starts the relay printing: with the Print.Template, or in the Print.Output format
+// unless it is text or no field is selected. With IPperLine the address arrays are
+// printed as repeated rows.
+func (imp *Importer) startOutput() {
+	if imp.Config.Print.Template != "" {
+		var err error
+		if imp.tmpl, err = template.New("relay").Funcs(templateFuncs).Parse(imp.Config.Print.Template); err != nil {
+			log.Fatal("Bad template: ", err)
+		}
+		return
+	}
+	columns := imp.printColumns()
+	if format := imp.Config.Print.Output; format == "" || format == "text" || len(columns) == 0 {
+		return
+	}
+	var err error
+	if imp.out, err = output.New(os.Stdout, imp.Config.Print.Output, columns...); err != nil {
+		log.Fatal(err)
+	}
+	imp.out.ExpandArrays = imp.Config.Print.IPperLine
+}
+
+// Value of a printed field: a TorRelayDetails field path (see onionoo.FieldValue), "as"
+// (including the As_number of older documents) or "host_names" (verified and unverified)
+func fieldValue(relay *onionoo.TorRelayDetails, path string) (interface{}, error) {
+	switch strings.ToLower(path) {
+	case "as":
+		return store.RelayAS(*relay), nil
+	case "host_names":
+		hostNames := make([]string, 0)
+		for hn := range relayHostNames(relay) {
+			hostNames = append(hostNames, hn)
+		}
+		sort.Strings(hostNames)
+		return hostNames, nil
+	}
+	return onionoo.FieldValue(*relay, path)
+}
+
+// Checks the names of Print.Fields, see fieldValue
+func CheckPrintFields(fields []string) error {
+	for _, f := range fields {
+		if _, err := fieldValue(&onionoo.TorRelayDetails{}, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *Importer) fieldValues(relay *onionoo.TorRelayDetails) []interface{} {
+	columns := imp.printColumns()
+	values := make([]interface{}, 0, len(columns))
+	for _, c := range columns {
+		v, err := fieldValue(relay, c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// Prints the selected fields of the relay with the started output
+func (imp *Importer) writeNodeRecord(relay *onionoo.TorRelayDetails) {
+	if err := imp.out.Write(imp.fieldValues(relay)...); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Prints the Print.Fields of the relay joined with the separator; arrays are space separated
+func (imp *Importer) printFields(relay *onionoo.TorRelayDetails) {
+	var cells []string
+	for _, v := range imp.fieldValues(relay) {
+		switch v := v.(type) {
+		case nil:
+			cells = append(cells, "")
+		case []string:
+			cells = append(cells, strings.Join(v, " "))
+		case string, bool, float64, uint64:
+			cells = append(cells, fmt.Sprint(v))
+		default:
+			js, _ := json.Marshal(v)
+			cells = append(cells, string(js))
+		}
+	}
+	fmt.Println(strings.Join(cells, imp.Config.Print.Separator))
+}
+
+// Prints the relay with the Print.Template, one line per relay unless the template ends
+// with a line break
+func (imp *Importer) printTemplate(relay *onionoo.TorRelayDetails) {
+	if err := imp.tmpl.Execute(os.Stdout, *relay); err != nil {
+		log.Fatal("Template: ", err)
+	}
+	if !strings.HasSuffix(imp.Config.Print.Template, "\n") {
+		fmt.Println()
+	}
+}
+
+func (imp *Importer) flushOutput() {
+	if imp.out != nil {
+		if err := imp.out.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func (imp *Importer) printNodeInfo(relay *onionoo.TorRelayDetails) {
+	switch {
+	case imp.tmpl != nil:
+		imp.printTemplate(relay)
+		return
+	case imp.out != nil:
+		imp.writeNodeRecord(relay)
+		return
+	case len(imp.Config.Print.Fields) > 0:
+		imp.printFields(relay)
+		return
+	}
+
+	var output []string
+	sep := imp.Config.Print.Separator
+	EXPAND_OR := "MULTIPLE_OR"
+	EXPAND_EX := "MULTIPLE_EX"
+
+	if imp.Config.Print.Nickname {
+		output = append(output, relay.Nickname)
+	}
+	if imp.Config.Print.Fingerprint {
+		output = append(output, relay.Fingerprint)
+	}
+	if imp.Config.Print.Or_addresses {
+		if imp.Config.Print.IPperLine && len(relay.Or_addresses) > 1 {
+			output = append(output, EXPAND_OR)
+		} else {
+			for _, i := range relay.Or_addresses {
+				output = append(output, i)
+			}
+		}
+	}
+	if imp.Config.Print.Exit_addresses {
+		if imp.Config.Print.IPperLine && len(relay.Exit_addresses) > 1 {
+			output = append(output, EXPAND_EX)
+		} else {
+			for _, i := range relay.Exit_addresses {
+				output = append(output, i)
+			}
+		}
+	}
+	if imp.Config.Print.Dir_address {
+		output = append(output, relay.Dir_address)
+	}
+	if imp.Config.Print.Country {
+		output = append(output, relay.Country)
+	}
+	if imp.Config.Print.AS {
+		output = append(output, relay.As)
+	}
+	if imp.Config.Print.Hostname {
+		hostNames := make([]string, 0)
+		for hn := range relayHostNames(relay) {
+			hostNames = append(hostNames, hn)
+		}
+		sort.Strings(hostNames)
+		output = append(output, strings.Join(hostNames, " "))
+	}
+	if imp.Config.Print.Flags {
+		output = append(output, fmt.Sprintf("%v", relay.Flags))
+	}
+
+	res := ""
+	for _, t := range output {
+		res += t
+		res += sep
+	}
+	if len(res) > 0 {
+		if strings.Contains(res, EXPAND_OR) {
+			for _, o := range relay.Or_addresses {
+				re := regexp.MustCompile(EXPAND_OR)
+				new := re.ReplaceAllString(res, o)
+				fmt.Println(new)
+			}
+		} else if strings.Contains(res, EXPAND_EX) {
+			for _, e := range relay.Exit_addresses {
+				re := regexp.MustCompile(EXPAND_EX)
+				new := re.ReplaceAllString(res, e)
+				fmt.Println(new)
+			}
+		} else {
+			fmt.Println(res)
+		}
+	}
+}
+
+// Returns the relays of new which are not identical in old
+func ExtractNewAndUpdatedRelays(old []onionoo.TorRelayDetails, new []onionoo.TorRelayDetails) []onionoo.TorRelayDetails {
+	ifPrintln(3, "extractNewAndUpdatedRelays: START")
+	defer ifPrintln(3, "extractNewAndUpdatedRelays: END")
+
+	var _result = make([]onionoo.TorRelayDetails, 0, 9000)
+
+	// Takes the old and new
+	// build map fingerprint to ID for the previous/old dataset, to accelerate lookups
+	fp2id := make(map[string]int)
+	for id, node := range old {
+		fp2id[node.Fingerprint] = id
+	}
+
+	for id, n := range new { // Iterate over the new entries and if an old entry is a complete match then do not add it to the result.
+		old_id, found := fp2id[n.Fingerprint]
+		if found {
+			// comare them
+			if reflect.DeepEqual(n, old[old_id]) {
+				delete(fp2id, n.Fingerprint)
+			} else {
+				// Nodes are different add to results
+				_result = append(_result, new[id])
+			}
+		} else { // Not found in old array (add to results)
+			_result = append(_result, new[id])
+		}
+	}
+
+	ifPrintln(1, fmt.Sprintf("Bulk entry mode new entries for this batch: %d.", len(_result)))
+	for _, i := range _result {
+		ifPrintln(2, "Adding node: "+i.Nickname+"/"+i.Fingerprint)
+	}
+	return _result
+}
+
+func (imp *Importer) processTorResponse(tor_response *onionoo.TorResponse) {
+	for _, relay := range tor_response.Relays {
+		ifPrintln(4, "\n== Processing node with fingerprint/nickname: "+relay.Fingerprint+"/"+relay.Nickname+" ===============================")
+
+		// Apply node filters
+		if !allStringsInSetMatch(&imp.Config.Filter.MatchFlags, &relay.Flags) { // If not a match skip iteration
+			continue
+		}
+
+		imp.printNodeInfo(&relay)
+
+		if imp.DB.Initialized() { // Database backend logic
+			// Clean up excess space left/right
+			relay.Contact = strings.TrimSpace(relay.Contact)
+
+			// The check below needs to be segmented so subtables can be updated independently of TorRelays
+			fp := relay.Fingerprint
+			ifPrintln(6, "Comparing records for fingerprint: "+fp)
+			if imp.recordsMatch(relay, imp.DB.LRD[fp]) { // MATCH - deal with node updates in DB
+				ifPrintln(4, "DEBUG: imp.DLTS: "+imp.DLTS+"; lrd[fp]['RecordLastSeen']: "+imp.DB.LRD[fp]["RecordLastSeen"])
+
+				// Record Last Seen timestamps match?
+				if imp.DLTS == imp.DB.LRD[fp]["RecordLastSeen"] { // Last seen matches - no updates; if DLTS < RLS, it means we are inserting older records
+					ifPrintln(4, fmt.Sprintf("DEBUG: TorRelay %s records RLS TIMESTAMPS MATCH!!! No DB update need at all", fp))
+				} else if imp.DLTS < imp.DB.LRD[fp]["RecordLastSeen"] { // Last seen matches - no updates; if DLTS < RLS, it means we are inserting older records
+					ifPrintln(4, fmt.Sprintf("DEBUG: TorRelay %s records RLS TIMESTAMP is NEWER than imported file!!! No DB update need at all", fp))
+				} else { // Update RecordLastSeen of TorRelay and dependent records
+					ifPrintln(4, fmt.Sprintf("DEBUG: TorRelay %s records RLS TIMESTAMPS do not match. Need to check relay addresses", fp))
+
+					// if Or, Exit and Dir have changed, however we are going to update their RLS to
+					// speed up queries against those index tables.
+					imp.updateRelayAddressesIfNeeded(&relay, &imp.DB.LRD)
+					imp.updateRelayHostNamesIfNeeded(&relay, imp.DB.LRD[fp]["ID_NodeFingerprints"])
+
+					ifPrintln(3, fmt.Sprintf("Updating RLS: %s/%s; TRID: %s; RLS(old/new): %s/%s.", fp, imp.DB.LRD[fp]["Nickname"], imp.DB.LRD[fp]["id"], imp.DB.LRD[fp]["RecordLastSeen"], imp.DLTS))
+					// ifPrintln(3, fmt.Sprintf("Updating RLS: Tor Relay(%s/%s): Record ID: %s (%s => %s)", fp, imp.DB.LRD[fp]["Nickname"], imp.DB.LRD[fp]["id"], imp.DB.LRD[fp]["RecordLastSeen"], imp.DLTS))
+					// Update the RecordLastSeen (RLS) timestamp
+					imp.DB.UpdateTorRelayRLS(imp.DB.LRD[fp]["id"], imp.DLTS)
+				}
+				continue
+			} else { // No match/New Record/Add to DB
+				imp.addNewTorRelayToDB(relay)
+			}
+		}
+	}
+	ifPrintln(5, "DONE: parsing Consensus data.")
+}
+
+func (imp *Importer) addNewTorRelayToDB(relay onionoo.TorRelayDetails) {
+	ifPrintln(4, fmt.Sprintf("func addNewTorRelayToDB(%v): ", relay))
+	defer ifPrintln(4, "func addNewTorRelayToDB: RETURN")
+
+	fpid := imp.DB.Value2ID("fingerprint", relay.Fingerprint)
+	countryid := imp.DB.NormalizeCountryID(relay.Country, relay.Country_name)
+	regionid := imp.DB.Value2ID("region", relay.Region_name)
+	cityid := imp.DB.Value2ID("city", relay.City_name)
+	asNumber := imp.DB.NormalizeASNumber(store.RelayAS(relay), relay.As_name)
+	platformid := imp.DB.Value2ID("platform", relay.Platform)
+	versionid := imp.DB.Value2ID("version", relay.Version)
+	versionStatus, recommended := store.VersionStatusValues(relay)
+	contactid := imp.DB.Value2ID("contact", relay.Contact)
+
+	js_exitp, _ := json.Marshal(relay.Exit_policy)
+	exitp := imp.DB.Value2ID("exitp", string(js_exitp))
+
+	js_exitps, _ := json.Marshal(relay.Exit_policy_summary)
+	exitps := imp.DB.Value2ID("exitps", string(js_exitps))
+
+	js_exitps6, _ := json.Marshal(relay.Exit_policy_v6_summary)
+	exitps6 := imp.DB.Value2ID("exitps6", string(js_exitps6))
+
+	// Store in intermediate variables before compacting the JSON object (before it's stored)
+	nick := relay.Nickname
+	lastChanged := relay.Last_changed_address_or_port
+	firstSeen := relay.First_seen
+	latitude, longitude := store.GeoValues(relay.Latitude, relay.Longitude)
+
+	// Cleanup/compact the JSON object before marshaling
+	cleanupRelayStruct(&relay)
+
+	jsFlags, _ := json.Marshal(relay.Flags)
+	jsRelay, _ := json.Marshal(relay)
+
+	ifPrintln(5, fmt.Sprintf("=============== INSERTING RECORD in TorRelays =================\n"+
+		"fpid: %s\ncountryid: %s\nregionid: %s\ncityid: %s\nrelay.Nickname: %s\n"+
+		"relay.Last_changed_address_or_port: %s\nrelay.First_seen: %s\nRecordTimeInserted: %s\nRecordLastSeen: %s\njsFlags: %s\njsRelay: %s\n",
+		fpid, countryid, regionid, cityid, nick, lastChanged, firstSeen, imp.DLTS, imp.DLTS, jsFlags, jsRelay))
+
+	lastID := imp.DB.AddTorRelay(fpid, countryid, regionid, cityid, asNumber, platformid, versionid, versionStatus, recommended, contactid,
+		exitp, exitps, exitps6, nick, lastChanged, firstSeen, imp.DLTS, imp.DLTS, latitude, longitude, jsFlags, jsRelay)
+	ifPrintln(4, "TorRelay LastInsertID: "+lastID)
+
+	// Add Or, Ex, Di addresses to the corresponding databases
+	imp.addNewRelayAddresses(lastID, fpid, relay.Or_addresses, relay.Exit_addresses, relay.Dir_address, relay.Unreachable_or_addresses)
+	imp.updateRelayHostNamesIfNeeded(&relay, fpid)
+}
+
+func (imp *Importer) addNewRelayAddresses(lastID string, fpid string, Or_addresses []string, Exit_addresses []string, Dir_address string, Unreachable_or_addresses []string) {
+	ifPrintln(4, fmt.Sprintf("func addNewRelayAddresses(%s,%s,%q,%q,%s,%q): ", lastID, fpid, Or_addresses, Exit_addresses, Dir_address, Unreachable_or_addresses))
+	defer ifPrintln(4, "func addNewRelayAddresses: RETURN")
+
+	ifPrintln(4, fmt.Sprintf("TorRelay: Loop Or_addresses: %v\n", Or_addresses))
+	if len(Or_addresses) > 0 {
+		for _, or := range Or_addresses {
+			ifPrintln(5, "TorRelay: Or_addresses: "+or)
+			imp.DB.AddToIP("Or", fpid, imp.DLTS, imp.DLTS, or)
+		}
+	}
+
+	ifPrintln(4, fmt.Sprintf("TorRelay: Loop Exit_addresses: %v\n", Exit_addresses))
+	if len(Exit_addresses) > 0 {
+		for _, ex := range Exit_addresses {
+			ifPrintln(5, "TorRelay: Exit_addresses: "+ex)
+			imp.DB.AddToIP("Ex", fpid, imp.DLTS, imp.DLTS, ex)
+		}
+	}
+
+	// relay.Dir_address is a string not an array
+	ifPrintln(4, "TorRelay: Dir_addresses: "+Dir_address)
+	if len(Dir_address) > 0 {
+		imp.DB.AddToIP("Di", fpid, imp.DLTS, imp.DLTS, Dir_address)
+	}
+
+	ifPrintln(4, fmt.Sprintf("TorRelay: Loop Unreachable_or_addresses: %v\n", Unreachable_or_addresses))
+	for _, un := range Unreachable_or_addresses {
+		ifPrintln(5, "TorRelay: Unreachable_or_addresses: "+un)
+		imp.DB.AddToIP("Un", fpid, imp.DLTS, imp.DLTS, un)
+	}
+}
+
+func (imp *Importer) updateRelayAddressesIfNeeded(relay *onionoo.TorRelayDetails, lrd *map[string](map[string]string)) {
+	ifPrintln(4, "func updateRelayAddressesIfNeeded(BEGIN): ")
+	defer ifPrintln(4, "func updateRelayAddressesIfNeeded: RETURN")
+
+	ifPrintln(6, "Checking OR...")
+	fp := (*relay).Fingerprint
+	if len(relay.Or_addresses) > 0 {
+		for _, or := range relay.Or_addresses {
+			imp.DB.UpdateIfNeededRelayAddressRLS("Or", (*lrd)[fp]["ID_NodeFingerprints"], imp.DLTS, or)
+		}
+	}
+
+	ifPrintln(6, "Checking Exit...")
+	if len(relay.Exit_addresses) > 0 {
+		for _, ex := range relay.Exit_addresses {
+			imp.DB.UpdateIfNeededRelayAddressRLS("Ex", (*lrd)[fp]["ID_NodeFingerprints"], imp.DLTS, ex)
+		}
+	}
+
+	ifPrintln(6, "Checking Directory...")
+	if len(relay.Dir_address) > 0 {
+		imp.DB.UpdateIfNeededRelayAddressRLS("Di", (*lrd)[fp]["ID_NodeFingerprints"], imp.DLTS, relay.Dir_address)
+	}
+
+	ifPrintln(6, "Checking Unreachable OR...")
+	for _, un := range relay.Unreachable_or_addresses {
+		imp.DB.UpdateIfNeededRelayAddressRLS("Un", (*lrd)[fp]["ID_NodeFingerprints"], imp.DLTS, un)
+	}
+}
+
+// Collects the reverse DNS names of a relay: name => verified.
+// The deprecated Host_name is only used if the newer fields do not list it
+// and is considered verified as Onionoo required a matching A record for it.
+func relayHostNames(relay *onionoo.TorRelayDetails) map[string]bool {
+	hostNames := make(map[string]bool)
+	for _, hn := range relay.Unverified_host_names {
+		if hn = store.NormalizeHostName(hn); len(hn) > 0 {
+			hostNames[hn] = false
+		}
+	}
+	for _, hn := range relay.Verified_host_names {
+		if hn = store.NormalizeHostName(hn); len(hn) > 0 {
+			hostNames[hn] = true
+		}
+	}
+	if hn := store.NormalizeHostName(relay.Host_name); len(hn) > 0 {
+		if _, ok := hostNames[hn]; !ok {
+			hostNames[hn] = true
+		}
+	}
+	return hostNames
+}
+
+func (imp *Importer) updateRelayHostNamesIfNeeded(relay *onionoo.TorRelayDetails, fpid string) {
+	ifPrintln(4, "func updateRelayHostNamesIfNeeded(BEGIN): ")
+	defer ifPrintln(4, "func updateRelayHostNamesIfNeeded: RETURN")
+
+	for hn, verified := range relayHostNames(relay) {
+		imp.DB.UpdateIfNeededRelayHostNameRLS(fpid, imp.DLTS, hn, verified)
+	}
+}
+
+func (imp *Importer) recordsMatch(relay onionoo.TorRelayDetails, lrdfp map[string]string) bool {
+	changes := store.DiffRelayFields(store.LRDFieldValues(lrdfp), store.RelayFieldValues(relay))
+	if len(changes) == 0 {
+		ifPrintln(4, "MATCHED: "+lrdfp["Fingerprint"])
+		return true
+	}
+
+	ifPrintln(3, "NO MATCH: Inserting TorRelay: "+relay.Nickname+"/"+relay.Fingerprint)
+	if logging.Verbosity >= 6 {
+		fmt.Println("(Current Relay data => LRD Cache data)")
+		fmt.Printf("Fingerprint: %s => %s\n", relay.Fingerprint, lrdfp["Fingerprint"])
+		fmt.Printf("Nickname: %s => %s\n", relay.Nickname, lrdfp["Nickname"])
+		for _, c := range changes {
+			fmt.Printf("FAIL %s: %s => %s\n", c.Field, c.New, c.Old)
+		}
+	}
+	return false
+}
+
+func cleanupRelayStruct(pr *onionoo.TorRelayDetails) {
+	pr.Nickname = ""
+	pr.Country = ""
+	pr.Country_name = ""
+	pr.Region_name = ""
+	pr.City_name = ""
+	pr.Platform = ""
+	pr.Version = ""
+	pr.Contact = ""
+	pr.Last_changed_address_or_port = ""
+	pr.First_seen = ""
+	pr.Latitude = 0
+	pr.Longitude = 0
+	pr.Fingerprint = ""
+	pr.Exit_policy = nil
+	pr.Exit_policy_summary = nil
+	pr.Exit_policy_v6_summary = nil
+	// Store those in the JSON for now, remove when thoroughly tested.
+	//	pr.Or_addresses = ""
+	//	pr.Exit_addresses = ""
+	//	pr.Dir_address = ""
+	// #### Deal with soon as it is highly volotile: pr.Last_seen = ""
+}
+
+/*func stringInSet( s *string, set []string) bool {
+	for _, curStr := range set {
+		if curStr == *s {
+			return true
+		}
+	}
+	return false
+}*/
+
+func allStringsInSetMatch(needles *[]string, set *[]string) bool {
+	if len(*needles) == 0 { // Optimization - if no needles - always true
+		return true
+	}
+NeedleLoop:
+	for _, curNeedle := range *needles {
+		for _, curStr := range *set {
+			if curStr == curNeedle {
+				continue NeedleLoop
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package importer
+
+import (
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+	"github.com/Harsh-bartariya/tor-history/store"
+)
+
+// Aggregates all the relays of the snapshot: the import filter does not apply, the
+// summary describes the whole network
+func computeNetworkSummary(tor_response *onionoo.TorResponse) *store.NetworkSummary {
+	ns := &store.NetworkSummary{
+		Flags:     make(map[string]uint64),
+		Countries: make(map[string]uint64),
+		ASes:      make(map[string]uint64),
+		Versions:  make(map[string]uint64),
+	}
+	for i := range tor_response.Relays {
+		relay := &tor_response.Relays[i]
+		ns.Relays++
+		if relay.Running {
+			ns.Running++
+		}
+		ns.AdvertisedBandwidth += relay.Advertised_bandwidth
+		ns.ConsensusWeight += relay.Consensus_weight
+		for _, flag := range relay.Flags {
+			ns.Flags[flag]++
+			switch flag {
+			case "Exit":
+				ns.Exits++
+				ns.ExitAdvertisedBandwidth += relay.Advertised_bandwidth
+				ns.ExitConsensusWeight += relay.Consensus_weight
+			case "Guard":
+				ns.Guards++
+			}
+		}
+		if relay.Country != "" {
+			ns.Countries[relay.Country]++
+		}
+		if relay.As != "" {
+			ns.ASes[relay.As]++
+		}
+		if relay.Version != "" {
+			ns.Versions[relay.Version]++
+		}
+	}
+	return ns
+}
@@ -0,0 +1,37 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package importer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+	"github.com/Harsh-bartariya/tor-history/store"
+)
+
+func TestComputeNetworkSummary(t *testing.T) {
+	tor_response := onionoo.TorResponse{Relays: []onionoo.TorRelayDetails{
+		{Running: true, Flags: []string{"Exit", "Fast", "Running"}, Advertised_bandwidth: 1000, Consensus_weight: 10,
+			Country: "de", As: "AS24940", Version: "0.4.8.9"},
+		{Running: true, Flags: []string{"Fast", "Guard", "Running"}, Advertised_bandwidth: 200, Consensus_weight: 2,
+			Country: "de", As: "AS24940", Version: "0.4.7.16"},
+		{Flags: []string{}, Advertised_bandwidth: 30, Consensus_weight: 0},
+	}}
+	want := &store.NetworkSummary{
+		Relays: 3, Running: 2, Exits: 1, Guards: 1,
+		AdvertisedBandwidth: 1230, ExitAdvertisedBandwidth: 1000, ConsensusWeight: 12, ExitConsensusWeight: 10,
+		Flags:     map[string]uint64{"Exit": 1, "Fast": 2, "Guard": 1, "Running": 2},
+		Countries: map[string]uint64{"de": 2},
+		ASes:      map[string]uint64{"AS24940": 2},
+		Versions:  map[string]uint64{"0.4.8.9": 1, "0.4.7.16": 1},
+	}
+	if got := computeNetworkSummary(&tor_response); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
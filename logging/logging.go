@@ -0,0 +1,36 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+// Package logging implements the verbosity levels shared by the tor-history packages.
+package logging
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+// Verbosity threshold and quiet mode, normally set from the configuration
+var (
+	Verbosity uint
+	Quiet     bool
+)
+
+// Prints an error message if verbosity level is less than Verbosity threshold
+// Observes "Quiet" and suppresses all verbosity
+func IfPrintln(level int, msg string) {
+	if Quiet && level > 0 { // stderr (level<0) is exempt from quiet
+		return
+	}
+	if uint(math.Abs(float64(level))) <= Verbosity {
+		if level < 0 {
+			fmt.Fprintf(os.Stderr, msg+"\n")
+		} else {
+			fmt.Fprintf(os.Stdout, msg+"\n")
+		}
+	}
+}
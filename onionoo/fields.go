@@ -0,0 +1,92 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package onionoo
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Names of the TorRelayDetails fields, as in the details document (lower case)
+func FieldNames() []string {
+	t := reflect.TypeOf(TorRelayDetails{})
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = strings.ToLower(t.Field(i).Name)
+	}
+	return names
+}
+
+// Checks that the path starts with a TorRelayDetails field, see FieldValue
+func CheckFieldPath(path string) error {
+	name := strings.SplitN(path, ".", 2)[0]
+	if _, ok := reflect.TypeOf(TorRelayDetails{}).FieldByNameFunc(func(f string) bool { return strings.EqualFold(f, name) }); !ok {
+		return errors.New("unknown relay field: " + name + " (" + strings.Join(FieldNames(), ", ") + ")")
+	}
+	return nil
+}
+
+// Value of a field of the relay selected by a dotted path: the field name (case
+// insensitive, e.g. "bandwidth_rate"), then map keys and array indexes, e.g.
+// "exit_policy_summary.accept" or "or_addresses.0". Arrays of strings are returned
+// as []string. Returns nil if the path does not lead to a value.
+func FieldValue(relay TorRelayDetails, path string) (interface{}, error) {
+	if err := CheckFieldPath(path); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(path, ".")
+	v := reflect.ValueOf(relay).FieldByNameFunc(func(f string) bool { return strings.EqualFold(f, parts[0]) })
+	for _, part := range parts[1:] {
+		for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil, nil
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Map:
+			v = v.MapIndex(reflect.ValueOf(part))
+			if !v.IsValid() {
+				return nil, nil
+			}
+		case reflect.Slice:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= v.Len() {
+				return nil, nil
+			}
+			v = v.Index(i)
+		default:
+			return nil, nil
+		}
+	}
+	return plainValue(v.Interface()), nil
+}
+
+// Dereferences pointers and turns arrays of strings decoded as []interface{} into []string
+func plainValue(v interface{}) interface{} {
+	switch a := v.(type) {
+	case *bool:
+		if a == nil {
+			return nil
+		}
+		return *a
+	case []interface{}:
+		strs := make([]string, len(a))
+		for i, e := range a {
+			s, ok := e.(string)
+			if !ok {
+				return v
+			}
+			strs[i] = s
+		}
+		return strs
+	}
+	return v
+}
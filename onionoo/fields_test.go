@@ -0,0 +1,46 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package onionoo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldValue(t *testing.T) {
+	resp, err := Parse([]byte(`{"relays": [{"nickname": "moria1", "bandwidth_rate": 512000,
+		"or_addresses": ["128.31.0.34:9101", "[2001:db8::1]:9101"], "recommended_version": false,
+		"exit_policy_summary": {"reject": ["1-65535"]}, "guard_probability": 0.25}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := resp.Relays[0]
+
+	for path, want := range map[string]interface{}{
+		"nickname":                   "moria1",
+		"Bandwidth_rate":             uint64(512000),
+		"or_addresses":               []string{"128.31.0.34:9101", "[2001:db8::1]:9101"},
+		"or_addresses.1":             "[2001:db8::1]:9101",
+		"or_addresses.2":             nil,
+		"recommended_version":        false,
+		"exit_policy_summary.reject": []string{"1-65535"},
+		"exit_policy_summary.accept": nil,
+		"guard_probability":          0.25,
+		"exit_addresses":             []string(nil),
+		"nickname.x":                 nil,
+	} {
+		got, err := FieldValue(relay, path)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("FieldValue(%q) = %#v, %v; want %#v", path, got, err, want)
+		}
+	}
+
+	if _, err := FieldValue(relay, "bandwidth"); err == nil {
+		t.Error("FieldValue accepted an unknown field")
+	}
+}
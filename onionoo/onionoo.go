@@ -1,17 +1,36 @@
-package main
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+// Package onionoo contains the Onionoo details document model and the
+// functions to download, read and parse it.
+package onionoo
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
-	"fmt"
-	"math"
-	"os"
+	"io/ioutil"
+	"net/http"
 	"strings"
-
-	"github.com/sensepost/maltegolocal/maltegolocal"
 )
 
-var g_db *DB
-var g_consensusDLTS string = ""
+type TorResponse struct {
+	Version                      string            // required; Onionoo protocol version string.
+	Next_major_version_scheduled string            // optional; UTC date (YYYY-MM-DD) when the next major protocol version is scheduled to be deployed. Omitted if no major protocol changes are planned.
+	Build_revision               string            // optional # Git revision of the Onionoo instance's software used to write this response, which will be omitted if unknown.
+	Relays_published             string            // required # UTC timestamp (YYYY-MM-DD hh:mm:ss) when the last known relay network status consensus started being valid. Indicates how recent the relay objects in this document are.
+	Relays_skipped               uint64            // optional # Number of skipped relays as requested by a positive "offset" parameter value. Omitted if zero.
+	Relays                       []TorRelayDetails // Relays array of objects // required # Array of relay objects as specified below.
+	Relays_truncated             uint64            // optional # Number of truncated relays as requested by a positive "limit" parameter value. Omitted if zero.
+	Bridges_published            string            // required # UTC timestamp (YYYY-MM-DD hh:mm:ss) when the last known bridge network status was published. Indicates how recent the bridge objects in this document are.
+	Bridges_skipped              uint64            // optional # Number of skipped bridges as requested by a positive "offset" parameter value. Omitted if zero.
+	Bridges                      []interface{}     // Bridges array of objects // required # Array of bridge objects as specified below.
+	Bridges_truncated            uint64            // optional # Number of truncated bridges as requested by a positive "limit" parameter value. Omitted if zero.
+}
 
 type TorRelayDetails struct {
 	Nickname                     string      `json:",omitempty"` // required # Relay nickname consisting of 1–19 alphanumerical characters. Turned into required field on March 14, 2018.
@@ -49,7 +68,7 @@ type TorRelayDetails struct {
 	Contact                      string      `json:",omitempty"` // optional # Contact address of the relay operator. Omitted if empty or if descriptor containing this information cannot be found.
 	Platform                     string      `json:",omitempty"` // optional # Platform string containing operating system and Tor version details. Omitted if empty or if descriptor containing this information cannot be found.
 	Version                      string      `json:",omitempty"` // optional # Tor software version without leading "Tor" as reported by the directory authorities in the "v" line of the consensus. Omitted if either the directory authorities or the relay did not report which version the relay runs or if the relay runs an alternative Tor implementation.
-	Recommended_version          bool        `json:",omitempty"` // optional # Boolean field saying whether the Tor software version of this relay is recommended by the directory authorities or not. Uses the relay version in the consensus. Omitted if either the directory authorities did not recommend versions, or the relay did not report which version it runs.
+	Recommended_version          *bool       `json:",omitempty"` // optional # Boolean field saying whether the Tor software version of this relay is recommended by the directory authorities or not. Uses the relay version in the consensus. Omitted if either the directory authorities did not recommend versions, or the relay did not report which version it runs. A pointer, so false is not omitted when marshaled.
 	Version_status               string      `json:",omitempty"` // optional # Status of the Tor software version of this relay based on the versions recommended by the directory authorities. Possible version statuses are: "recommended" if a version is listed as recommended; "experimental" if a version is newer than every recommended version; "obsolete" if a version is older than every recommended version; "new in series" if a version has other recommended versions with the same first three components, and the version is newer than all such recommended versions, but it is not newer than every recommended version; "unrecommended" if none of the above conditions hold. Omitted if either the directory authorities did not recommend versions, or the relay did not report which version it runs. Added on April 6, 2018.
 	Effective_family             []string    `json:",omitempty"` // optional # Array of fingerprints of relays that are in an effective, mutual family relationship with this relay. These relays are part of this relay's family and they consider this relay to be part of their family. Always contains the relay's own fingerprint. Omitted if the descriptor containing this information cannot be found. Updated to always include the relay's own fingerprint on March 14, 2018.
 	Alleged_family               []string    `json:",omitempty"` // optional # Array of fingerprints of relays that are not in an effective, mutual family relationship with this relay. These relays are part of this relay's family but they don't consider this relay to be part of their family. Omitted if empty or if descriptor containing this information cannot be found.
@@ -62,182 +81,33 @@ type TorRelayDetails struct {
 	Unreachable_or_addresses     []string    `json:",omitempty"` // optional # Array of IPv4 or IPv6 addresses and TCP ports or port lists where the relay claims in its descriptor to accept onion-routing connections but that the directory authorities failed to confirm as reachable. Contains only additional addresses of a relay that are found unreachable and only as long as a minority of directory authorities performs reachability tests on these additional addresses. Relays with an unreachable primary address are not included in the network status consensus and excluded entirely. Likewise, relays with unreachable additional addresses tested by a majority of directory authorities are not included in the network status consensus and excluded here, too. If at any point network status votes will be added to the processing, relays with unreachable addresses will be included here. Addresses are in arbitrary order. IPv6 hex characters are all lower-case. Omitted if empty.
 }
 
-type TorHistoryConfig struct {
-	Verbosity uint `yaml:"verbosity"`
-	Quiet     bool // Overrides and level of verbosity; cannot be configured in config file
-
-	DBServer struct {
-		Enabled      bool   //`yaml:"enabled"`
-		Port         string `yaml:"port"`
-		Host         string `yaml:"host"`
-		DBName       string `yaml:"database"`
-		Username     string `yaml:"username"`
-		Password     string `yaml:"password"`
-		ReInitCaches int    `yaml:"reinit-caches"`
-	} `yaml:"dbserver"`
-	Tor struct {
-		ConsensusURL     string `yaml:"url"`      // Consensus URL
-		Filename         string `yaml:"Filename"` // Input filename
-		ConsensusDLT     string
-		ConsensusDLT_fmt string
-
-		ExtractDLTfromFilename       bool
-		ExtractDLTfromFilename_regex string
-	} `yaml:"consensus"`
-	Backup struct {
-		Filename string `yaml:"filename"`
-		Gzip     bool   `yaml:"gzip"`
-	} `yaml:"backup"`
-	Print struct {
-		Separator      string
-		Nickname       bool
-		Fingerprint    bool
-		Or_addresses   bool
-		Exit_addresses bool
-		Dir_address    bool
-		Country        bool
-		AS             bool
-		Hostname       bool
-		Flags          bool
-		IPperLine      bool
-	} `yaml:"Print"`
-	Filter struct {
-		Running     bool
-		Hibernating bool
-		matchFlags  []string
-	}
+// Parses an Onionoo details document
+func Parse(data []byte) (TorResponse, error) {
+	var tor_response TorResponse
+	err := json.NewDecoder(bytes.NewReader(data)).Decode(&tor_response)
+	return tor_response, err
 }
 
-var g_config TorHistoryConfig
-
-// Prints an error message if verbosity level is less than g_config.Verbosity threshold
-// Observes "Quiet" and suppresses all verbosity
-func ifPrintln(level int, msg string) {
-	if g_config.Quiet && level > 0 { // stderr (level<0) is exempt from quiet
-		return
-	}
-	if uint(math.Abs(float64(level))) <= g_config.Verbosity {
-		if level < 0 {
-			fmt.Fprintf(os.Stderr, msg+"\n")
-		} else {
-			fmt.Fprintf(os.Stdout, msg+"\n")
-		}
+// Reads a details document from a file. Files with a .gz suffix are decompressed.
+func ReadFile(fn string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil || !strings.HasSuffix(fn, ".gz") {
+		return data, err
 	}
-}
-
-func main() {
-	defer cleanup()
-
-	lt := maltegolocal.ParseLocalArguments(os.Args)
-	EntityValue := lt.Value
-	TRX := maltegolocal.MaltegoTransform{}
-
-	args := ""
-	for k, v := range lt.Values {
-		args += k + "=>" + v + "; "
-	}
-
-	//	g_db = NewDB(cfg.DBServer.Username, cfg.DBServer.Password, cfg.DBServer.Host, cfg.DBServer.Port, cfg.DBServer.DBName)
-	g_db = NewDB("tor-rw", "", "localhost", "3306", "tor_history")
-
-	for k, v := range lt.Values {
-		switch k {
-		case "countrysc": // Maltego typ country field
-			EntityValue = strings.ToLower(v)
-			lookupByCC(&TRX, EntityValue)
-			break
-		case "properties.shodan.country": // Shodan type country field
-			EntityValue = strings.ToLower(EntityValue)
-			lookupByCC(&TRX, EntityValue)
-			break
-		case "ipv4-address":
-			lookupByIP(&TRX, EntityValue)
-			break
-		case "email":
-			lookupByEmail(&TRX, EntityValue)
-			break
-		}
-	}
-	TRX.AddUIMessage("completed!", "Inform")
-	fmt.Println(TRX.ReturnOutput())
-}
-
-func concatIDs(ids map[string]string) string {
-	idList := ""
-	for id, _ := range ids {
-		idList += id + ", "
-	}
-	if len(idList) > 1 {
-		idList = idList[0 : len(idList)-2]
-	}
-	return idList
-}
-
-func lookupByEmail(TRX *maltegolocal.MaltegoTransform, EntityValue string) {
-	ids := g_db.getLatestTRsIDsByEmail(EntityValue)
-
-	TRX.AddUIMessage(fmt.Sprintf("Records matching: %d\n", len(ids)), "Inform")
-	idList := concatIDs(ids)
-
-	relays := g_db.getTorRelaysByIDStringList(idList)
-	for _, relay := range relays {
-		createMaltegoNode(TRX, relay)
-	}
-}
-
-func lookupByIP(TRX *maltegolocal.MaltegoTransform, EntityValue string) {
-	ids := g_db.getLatestTRsIDsByIP(EntityValue)
-
-	TRX.AddUIMessage(fmt.Sprintf("Records matching: %d\n", len(ids)), "Inform")
-	idList := concatIDs(ids)
-
-	relays := g_db.getTorRelaysByIDStringList(idList)
-	for _, relay := range relays {
-		createMaltegoNode(TRX, relay)
-	}
-}
-
-func lookupByCC(TRX *maltegolocal.MaltegoTransform, EntityValue string) {
-	ids := g_db.getLatestTRsIDsByCountryCode(EntityValue)
-	TRX.AddUIMessage(fmt.Sprintf("Records matching: %d\n", len(ids)), "Inform")
-	idList := concatIDs(ids)
-
-	TRX.AddUIMessage("DEBUG: IDs list: "+idList, "Inform")
-	relays := g_db.getTorRelaysByIDStringList(idList)
-	for _, relay := range relays {
-		createMaltegoNode(TRX, relay)
-	}
-}
-
-func createMaltegoNode(TRX *maltegolocal.MaltegoTransform, relay map[string]string) {
-	BaseEnt := TRX.AddEntity("ktt.TORNode", relay["Nickname"]+"\n"+relay["Fingerprint"])
-	for k, v := range relay {
-		if k == "ID" {
-			continue
-		}
-		BaseEnt.AddProperty(k, "", "nostrict", v)
-	}
-	// Dynamic properties
-	var details TorRelayDetails
-	err := json.Unmarshal([]byte(relay["jsd"]), &details)
+	zr, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
-		TRX.AddUIMessage(fmt.Sprintf("Problem unmarshalling: %s\n %s", relay["jsd"], err), "Inform")
-	}
-	for _, ip := range details.Exit_addresses {
-		BaseEnt.AddProperty("Exit_addresses", "Exit Address", "nostrict", ip)
-	}
-	for _, ip := range details.Or_addresses {
-		BaseEnt.AddProperty("Or_addresses", "Router Address", "nostrict", ip)
-	}
-	if len(details.Dir_address) > 0 {
-		BaseEnt.AddProperty("Dir_addresses", "Directory Address", "nostrict", details.Dir_address)
+		return nil, err
 	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
 }
 
-func cleanup() {
-	ifPrintln(5, "Starting cleanup()")
-	if g_db != nil {
-		g_db.Close()
+// Downloads a details document
+func Download(url string) ([]byte, error) {
+	http_session, err := http.Get(url)
+	if err != nil {
+		return nil, err
 	}
-	ifPrintln(5, "Completed cleanup()")
+	defer http_session.Body.Close()
+	return ioutil.ReadAll(http_session.Body)
 }
@@ -0,0 +1,211 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+// Package output writes records (rows of named columns) in machine-readable formats:
+// JSON, NDJSON, CSV, TSV and aligned tables. It serves the consensus printing as well
+// as the query results.
+package output
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Supported formats
+var Formats = []string{"json", "ndjson", "csv", "tsv", "table"}
+
+// Writes records in one of Formats. The values of a record are strings, []string or
+// any other JSON value. JSON and NDJSON keep the arrays; the other formats render them
+// as JSON, or as repeated rows (one per element) when ExpandArrays is set.
+type Formatter struct {
+	ExpandArrays bool
+
+	format  string
+	columns []string
+	w       *bufio.Writer
+	csv     *csv.Writer
+	table   *tabwriter.Writer
+	records int
+}
+
+// Returns an error if the format is not one of Formats
+func CheckFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return errors.New("unknown output format: " + format + " (" + strings.Join(Formats, ", ") + ")")
+}
+
+// New formatter of the columns writing to w. CSV, TSV and tables start with a header
+// row of the column names.
+func New(w io.Writer, format string, columns ...string) (*Formatter, error) {
+	if err := CheckFormat(format); err != nil {
+		return nil, err
+	}
+	f := &Formatter{format: format, columns: columns, w: bufio.NewWriter(w)}
+	switch format {
+	case "csv", "tsv":
+		f.csv = csv.NewWriter(f.w)
+		if format == "tsv" {
+			f.csv.Comma = '\t'
+		}
+	case "table":
+		f.table = tabwriter.NewWriter(f.w, 0, 8, 2, ' ', 0)
+	}
+	return f, nil
+}
+
+// Writes a record: one value per column, in order
+func (f *Formatter) Write(values ...interface{}) error {
+	if len(values) != len(f.columns) {
+		return errors.New("output: record does not match the columns")
+	}
+	if f.records == 0 {
+		if err := f.writeHeader(); err != nil {
+			return err
+		}
+	}
+	f.records++
+	values = append([]interface{}(nil), values...) // The caller's slice is left as is
+	for i, v := range values {
+		if a, ok := v.([]string); ok && a == nil {
+			values[i] = []string{} // [] rather than null
+		}
+	}
+
+	switch f.format {
+	case "json", "ndjson":
+		return f.writeJSON(values)
+	}
+	for _, row := range f.rows(values) {
+		var err error
+		if f.csv != nil {
+			err = f.csv.Write(row)
+		} else {
+			_, err = io.WriteString(f.table, strings.Join(row, "\t")+"\n")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Formatter) writeHeader() error {
+	switch f.format {
+	case "json":
+		_, err := f.w.WriteString("[\n")
+		return err
+	case "ndjson":
+		return nil
+	case "table":
+		_, err := io.WriteString(f.table, strings.Join(f.columns, "\t")+"\n")
+		return err
+	}
+	return f.csv.Write(f.columns)
+}
+
+func (f *Formatter) writeJSON(values []interface{}) error {
+	// An object with the columns in order; a map would sort them
+	var b strings.Builder
+	b.WriteString("{")
+	for i, column := range f.columns {
+		key, _ := json.Marshal(column)
+		value, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}")
+
+	if f.format == "json" && f.records > 1 {
+		f.w.WriteString(",\n")
+	}
+	f.w.WriteString(b.String())
+	if f.format == "ndjson" {
+		f.w.WriteString("\n")
+	}
+	return nil
+}
+
+// Cells of the record: a single row, or one row per combination of the array
+// elements when ExpandArrays is set
+func (f *Formatter) rows(values []interface{}) [][]string {
+	rows := [][]string{make([]string, 0, len(values))}
+	for _, v := range values {
+		cells := []string{f.cell(v)}
+		if a, ok := v.([]string); ok && f.ExpandArrays && len(a) > 0 {
+			cells = a
+		}
+		var expanded [][]string
+		for _, row := range rows {
+			for _, c := range cells {
+				expanded = append(expanded, append(row[:len(row):len(row)], c))
+			}
+		}
+		rows = expanded
+	}
+	return rows
+}
+
+// Text of a value in a CSV, TSV or table cell. Tables are aligned on tabs, so
+// tabs and line breaks become spaces there.
+func (f *Formatter) cell(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case nil:
+		s = ""
+	default:
+		js, _ := json.Marshal(v)
+		s = string(js)
+	}
+	if f.table != nil {
+		s = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(s)
+	}
+	return s
+}
+
+// Completes the output (the JSON array, the table alignment) and flushes it
+func (f *Formatter) Flush() error {
+	if f.records == 0 { // Header only
+		if err := f.writeHeader(); err != nil {
+			return err
+		}
+	}
+	switch f.format {
+	case "json":
+		if f.records > 0 {
+			f.w.WriteString("\n")
+		}
+		f.w.WriteString("]\n")
+	case "csv", "tsv":
+		f.csv.Flush()
+		if err := f.csv.Error(); err != nil {
+			return err
+		}
+	case "table":
+		if err := f.table.Flush(); err != nil {
+			return err
+		}
+	}
+	return f.w.Flush()
+}
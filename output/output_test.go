@@ -0,0 +1,92 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package output
+
+import (
+	"bytes"
+	"testing"
+)
+
+func format(t *testing.T, name string, expand bool, records ...[]interface{}) string {
+	var b bytes.Buffer
+	f, err := New(&b, name, "nickname", "or_addresses", "flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ExpandArrays = expand
+	for _, r := range records {
+		if err := f.Write(r...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+var (
+	relay1 = []interface{}{"moria,1", []string{"128.31.0.34:9101", "[2001:db8::1]:9101"}, []string{"Fast", "Running"}}
+	relay2 = []interface{}{"say \"hi\"", []string(nil), []string{"Exit"}}
+)
+
+func TestFormats(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		expand bool
+		want   string
+	}{
+		{"json", false, "[\n" +
+			`{"nickname":"moria,1","or_addresses":["128.31.0.34:9101","[2001:db8::1]:9101"],"flags":["Fast","Running"]},` + "\n" +
+			`{"nickname":"say \"hi\"","or_addresses":[],"flags":["Exit"]}` + "\n]\n"},
+		{"ndjson", false, `{"nickname":"moria,1","or_addresses":["128.31.0.34:9101","[2001:db8::1]:9101"],"flags":["Fast","Running"]}` + "\n" +
+			`{"nickname":"say \"hi\"","or_addresses":[],"flags":["Exit"]}` + "\n"},
+		{"csv", false, "nickname,or_addresses,flags\n" +
+			`"moria,1","[""128.31.0.34:9101"",""[2001:db8::1]:9101""]","[""Fast"",""Running""]"` + "\n" +
+			`"say ""hi""",[],"[""Exit""]"` + "\n"},
+		{"csv", true, "nickname,or_addresses,flags\n" +
+			`"moria,1",128.31.0.34:9101,Fast` + "\n" +
+			`"moria,1",128.31.0.34:9101,Running` + "\n" +
+			`"moria,1",[2001:db8::1]:9101,Fast` + "\n" +
+			`"moria,1",[2001:db8::1]:9101,Running` + "\n" +
+			`"say ""hi""",[],Exit` + "\n"},
+		{"tsv", true, "nickname\tor_addresses\tflags\n" +
+			"moria,1\t128.31.0.34:9101\tFast\n" +
+			"moria,1\t128.31.0.34:9101\tRunning\n" +
+			"moria,1\t[2001:db8::1]:9101\tFast\n" +
+			"moria,1\t[2001:db8::1]:9101\tRunning\n" +
+			"\"say \"\"hi\"\"\"\t[]\tExit\n"},
+	} {
+		if got := format(t, tc.format, tc.expand, relay1, relay2); got != tc.want {
+			t.Errorf("%s (expand: %v):\n%s\nwant:\n%s", tc.format, tc.expand, got, tc.want)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	got := format(t, "table", false, []interface{}{"a\tb", []string{"x"}, nil})
+	want := "nickname  or_addresses  flags\n" +
+		"a b       [\"x\"]         \n"
+	if got != want {
+		t.Errorf("table:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestNoRecords(t *testing.T) {
+	for name, want := range map[string]string{"json": "[\n]\n", "ndjson": "", "csv": "nickname,or_addresses,flags\n"} {
+		if got := format(t, name, false); got != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := New(nil, "xml", "a"); err == nil {
+		t.Error("New accepted an unknown format")
+	}
+}
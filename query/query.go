@@ -0,0 +1,408 @@
+// Package query resolves lookups against the tor-history store into relay records.
+// Each lookup returns the latest matching TorRelays record per relay, keyed by TorRelays ID.
+// Lookups are scoped to a [from, to] time range (see store.ParseTimeRange): only records
+// overlapping the range match. Pass store.MinTime, store.MaxTime for all time and
+// from == to for a point in time.
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/exitpolicy"
+	"github.com/Harsh-bartariya/tor-history/logging"
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+	"github.com/Harsh-bartariya/tor-history/store"
+)
+
+var ifPrintln = logging.IfPrintln
+
+type Client struct {
+	DB *store.DB
+}
+
+func New(db *store.DB) *Client {
+	return &Client{DB: db}
+}
+
+// Relay records for a map of TorRelays ID => NodeFingerprints ID, as returned by the store lookups
+func (q *Client) Relays(ids map[string]string) map[string](map[string]string) {
+	if len(ids) == 0 {
+		return make(map[string](map[string]string))
+	}
+	idList := make([]string, 0, len(ids))
+	for id := range ids {
+		idList = append(idList, id)
+	}
+	return q.DB.GetTorRelaysByIDs(idList)
+}
+
+func (q *Client) ByCountryCode(cc string, from string, to string) map[string](map[string]string) {
+	return q.Relays(q.DB.GetLatestTRsIDsByCountryCode(strings.ToLower(cc), from, to))
+}
+
+func (q *Client) ByEmail(email string, from string, to string) map[string](map[string]string) {
+	return q.Relays(q.DB.GetLatestTRsIDsByEmail(email, from, to))
+}
+
+func (q *Client) ByIP(ip string, from string, to string) map[string](map[string]string) {
+	return q.Relays(q.DB.GetLatestTRsIDsByIP(ip, from, to))
+}
+
+func (q *Client) ByPGP(key string, from string, to string) map[string](map[string]string) {
+	return q.Relays(q.DB.GetLatestTRsIDsByPGP(key, from, to))
+}
+
+func (q *Client) ByContactDomain(domain string, from string, to string) map[string](map[string]string) {
+	return q.Relays(q.DB.GetLatestTRsIDsByContactDomain(domain, from, to))
+}
+
+// Relays which ran the Tor version, or a release of the series ("0.4.5"), in the [from, to] time range
+func (q *Client) ByVersion(version string, from string, to string) map[string](map[string]string) {
+	return q.Relays(q.DB.GetLatestTRsIDsByVersion(version, from, to))
+}
+
+// Relays whose platform string contained platform (e.g. "Linux") in the [from, to] time range
+func (q *Client) ByPlatform(platform string, from string, to string) map[string](map[string]string) {
+	return q.Relays(q.DB.GetLatestTRsIDsByPlatform(platform, from, to))
+}
+
+// Relays which ran an obsolete or unrecommended Tor version in the [from, to] time range
+func (q *Client) ByUnrecommendedVersion(from string, to string) map[string](map[string]string) {
+	return q.Relays(q.DB.GetLatestTRsIDsByUnrecommendedVersion(from, to))
+}
+
+// Number of relays running a Tor version
+type VersionCount struct {
+	Version string
+	Status  string // Version_status; empty when unknown
+	Relays  int
+}
+
+// Tor versions of the relays running at the time, most used first
+func (q *Client) VersionDistribution(at string) []VersionCount {
+	var counts []VersionCount
+	for _, row := range q.DB.GetVersionDistribution(at) {
+		n, _ := strconv.Atoi(row["Relays"])
+		counts = append(counts, VersionCount{Version: row["VersionName"], Status: row["Version_status"], Relays: n})
+	}
+	return counts
+}
+
+// Relays which used a nickname matching the pattern in the [from, to] time range.
+// See store.NicknameMatchModes; maxDistance is only used by the fuzzy mode.
+func (q *Client) ByNickname(pattern string, mode string, maxDistance int, from string, to string) (map[string](map[string]string), error) {
+	ids, err := q.DB.GetLatestTRsIDsByNickname(pattern, mode, maxDistance, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return q.Relays(ids), nil
+}
+
+// Matches the reverse DNS names of the relays as well as the domains in their contact info
+func (q *Client) ByHostName(hostName string, from string, to string) map[string](map[string]string) {
+	return q.Relays(mergeByFingerprint(q.DB.GetLatestTRsIDsByHostName(hostName, from, to), q.DB.GetLatestTRsIDsByContactDomain(hostName, from, to)))
+}
+
+// A relay seen at an IP address, in one role over one interval
+type AddressMatch struct {
+	Fingerprint string
+	Role        string // or, exit, dir or unreachable-or
+	IP          string
+	Port        string // empty for exit addresses
+	From        string // RecordTimeInserted of the address
+	To          string // RecordLastSeen of the address
+	Relay       map[string]string
+}
+
+// Relays which used the IP (v4 or v6) in the [from, to] time range, with their role and
+// interval. An empty port matches all ports. For a point in time pass from == to.
+// Relay holds the relay record valid at the time (nil if none was recorded).
+func (q *Client) ByIPAt(ip string, port string, from string, to string) []AddressMatch {
+	return q.addressMatches(q.DB.GetAddressIntervalsByIP(ip, port, from, to))
+}
+
+// Same as ByIPAt for every address of a v4 or v6 CIDR block (e.g. 192.0.2.0/24)
+func (q *Client) ByCIDR(cidr string, port string, from string, to string) []AddressMatch {
+	return q.addressMatches(q.DB.GetAddressIntervalsByCIDR(cidr, port, from, to))
+}
+
+// Same as ByIPAt for every address between first and last (inclusive)
+func (q *Client) ByIPRange(first string, last string, port string, from string, to string) []AddressMatch {
+	return q.addressMatches(q.DB.GetAddressIntervalsByRange(first, last, port, from, to))
+}
+
+func (q *Client) addressMatches(rows [](map[string]string)) []AddressMatch {
+	ids := make(map[string]string)
+	for _, row := range rows {
+		if row["ID_TorRelays"] != "" {
+			ids[row["ID_TorRelays"]] = row["Fingerprint"]
+		}
+	}
+	relays := q.Relays(ids)
+
+	var matches []AddressMatch
+	for _, row := range rows {
+		matches = append(matches, AddressMatch{
+			Fingerprint: row["Fingerprint"],
+			Role:        row["Role"],
+			IP:          row["ip"],
+			Port:        row["port"],
+			From:        row["RecordTimeInserted"],
+			To:          row["RecordLastSeen"],
+			Relay:       relays[row["ID_TorRelays"]],
+		})
+	}
+	return matches
+}
+
+// Latest relay record of every relay in matches, keyed by fingerprint
+func LatestRelays(matches []AddressMatch) map[string](map[string]string) {
+	relays := make(map[string](map[string]string))
+	for _, m := range matches {
+		keepLatestRelay(relays, m.Fingerprint, m.Relay)
+	}
+	return relays
+}
+
+// Keeps relay as the record of the fingerprint unless relays holds a later one (by RecordLastSeen)
+func keepLatestRelay(relays map[string](map[string]string), fingerprint string, relay map[string]string) {
+	if relay == nil {
+		return
+	}
+	if prev, ok := relays[fingerprint]; !ok || prev["RecordLastSeen"] <= relay["RecordLastSeen"] {
+		relays[fingerprint] = relay
+	}
+}
+
+// A relay in an autonomous system over one interval
+type ASInterval struct {
+	AS          string // "AS1234"
+	ASName      string
+	Fingerprint string
+	From        string // RecordTimeInserted of the first record in the AS
+	To          string // RecordLastSeen of the last record in the AS
+	Relay       map[string]string
+}
+
+// Relays which were in the AS in the [from, to] time range, with the intervals they spent
+// in it. as is an AS number ("AS1234" or "1234") or part of an AS name. Relay holds the
+// latest record of the interval.
+func (q *Client) ByAS(as string, from string, to string) []ASInterval {
+	rows := q.DB.GetASIntervals(as, from, to)
+	ids := make(map[string]string)
+	for _, row := range rows {
+		ids[row["ID_TorRelays"]] = row["Fingerprint"]
+	}
+	relays := q.Relays(ids)
+
+	var intervals []ASInterval
+	for _, row := range rows {
+		intervals = append(intervals, ASInterval{
+			AS:          "AS" + row["ASNumber"],
+			ASName:      row["ASName"],
+			Fingerprint: row["Fingerprint"],
+			From:        row["RecordTimeInserted"],
+			To:          row["RecordLastSeen"],
+			Relay:       relays[row["ID_TorRelays"]],
+		})
+	}
+	return intervals
+}
+
+// Latest relay record of every relay in intervals, keyed by fingerprint
+func LatestASRelays(intervals []ASInterval) map[string](map[string]string) {
+	relays := make(map[string](map[string]string))
+	for _, i := range intervals {
+		keepLatestRelay(relays, i.Fingerprint, i.Relay)
+	}
+	return relays
+}
+
+// A contact matching a search and a relay which used it over one interval
+type ContactMatch struct {
+	Contact     string
+	Score       float64 // Full-text relevance; higher is better
+	Fingerprint string
+	From        string // RecordTimeInserted of the first record with the contact
+	To          string // RecordLastSeen of the last record with the contact
+	Relay       map[string]string
+}
+
+// Contacts matching words, an e-mail address or a domain (see store.ContactSearchQuery),
+// most relevant first, with the relays which used them in the [from, to] time range.
+// Relay holds the latest record of the interval.
+func (q *Client) SearchContacts(text string, from string, to string) []ContactMatch {
+	rows := q.DB.SearchContacts(text, from, to)
+	ids := make(map[string]string)
+	for _, row := range rows {
+		ids[row["ID_TorRelays"]] = row["Fingerprint"]
+	}
+	relays := q.Relays(ids)
+
+	var matches []ContactMatch
+	for _, row := range rows {
+		score, _ := strconv.ParseFloat(row["Score"], 64)
+		matches = append(matches, ContactMatch{
+			Contact:     row["ContactName"],
+			Score:       score,
+			Fingerprint: row["Fingerprint"],
+			From:        row["RecordTimeInserted"],
+			To:          row["RecordLastSeen"],
+			Relay:       relays[row["ID_TorRelays"]],
+		})
+	}
+	return matches
+}
+
+// One version of a relay: the TorRelays record valid from From to To rebuilt into
+// complete relay details, with the address and host name intervals of the period
+type TimelineEntry struct {
+	From       string
+	To         string
+	Relay      onionoo.TorRelayDetails
+	Addresses  []store.HistoryAddress
+	Host_names []store.HistoryHostName
+}
+
+// Every recorded version of the relay, oldest first
+func (q *Client) Timeline(fingerprint string) []TimelineEntry {
+	var timeline []TimelineEntry
+	for _, rec := range q.DB.GetRelayTimeline(fingerprint) {
+		relay, err := rec.RelayDetails()
+		if err != nil {
+			ifPrintln(-1, "Timeline: "+rec.Fingerprint+" "+rec.Record_time_inserted+": "+err.Error())
+		}
+		timeline = append(timeline, TimelineEntry{rec.Record_time_inserted, rec.Record_last_seen, relay, rec.Addresses, rec.Host_names})
+	}
+	return timeline
+}
+
+// Changes introduced by a version of a relay
+type RelayChange struct {
+	At      string // RecordTimeInserted of the new version
+	Changes []store.FieldChange
+}
+
+// Change log of the relay: the fields which differ between each pair of consecutive versions
+func (q *Client) Changes(fingerprint string) []RelayChange {
+	timeline := q.Timeline(fingerprint)
+	var changes []RelayChange
+	for i := 1; i < len(timeline); i++ {
+		diff := store.DiffRelays(timeline[i-1].Relay, timeline[i].Relay)
+		if len(diff) > 0 {
+			changes = append(changes, RelayChange{timeline[i].From, diff})
+		}
+	}
+	return changes
+}
+
+// Whether the exit policy of the relay at the time allowed traffic to ip:port.
+// found is false if the relay was not recorded at the time; reason is the deciding rule.
+func (q *Client) ExitAllowed(fingerprint string, at string, ip string, port uint16) (allowed bool, found bool, reason string, err error) {
+	dest := net.ParseIP(strings.TrimSpace(ip))
+	if dest == nil {
+		return false, false, "", errors.New("invalid IP address: " + ip)
+	}
+	rec := q.DB.GetRelayRecordAt(fingerprint, at)
+	if rec == nil {
+		return false, false, "", nil
+	}
+	relay, err := rec.RelayDetails()
+	if err != nil {
+		return false, true, "", err
+	}
+	allowed, reason, err = exitpolicy.RelayAllows(relay, dest, port)
+	return allowed, true, reason, err
+}
+
+// An exit relay whose policy allowed a destination
+type ExitMatch struct {
+	Fingerprint   string
+	Nickname      string
+	ExitAddresses []string // Exit addresses at the time; the OR addresses if none were recorded
+	Reason        string   // Deciding exit policy rule
+	Relay         map[string]string
+}
+
+// ExoneraTor in reverse: the relays which had the Exit flag, were present at the time
+// and whose exit policy allowed traffic to ip:port
+func (q *Client) ExitsReaching(ip string, port uint16, at string) ([]ExitMatch, error) {
+	dest := net.ParseIP(strings.TrimSpace(ip))
+	if dest == nil {
+		return nil, errors.New("invalid IP address: " + ip)
+	}
+
+	// Relays share a small number of policies; parse each once
+	policies := make(map[string]*exitpolicy.RelayPolicy)
+	var matches []ExitMatch
+	for _, row := range q.DB.GetExitRelaysAt(at) {
+		rp, ok := policies[row["PolicyKey"]]
+		if !ok {
+			var relay onionoo.TorRelayDetails
+			var err error
+			if err = unmarshalIfSet(row["ExitPolicy"], &relay.Exit_policy); err == nil {
+				if err = unmarshalIfSet(row["ExitPolicySummary"], &relay.Exit_policy_summary); err == nil {
+					err = unmarshalIfSet(row["ExitPolicyV6Summary"], &relay.Exit_policy_v6_summary)
+				}
+			}
+			if err == nil {
+				rp, err = exitpolicy.NewRelayPolicy(relay)
+			}
+			if err != nil {
+				ifPrintln(-1, "ExitsReaching: "+row["Fingerprint"]+": "+err.Error())
+			}
+			policies[row["PolicyKey"]] = rp
+		}
+		if rp == nil {
+			continue
+		}
+
+		allowed, reason := rp.Allows(dest, port)
+		if !allowed {
+			continue
+		}
+		addresses := row["ExitAddresses"]
+		if addresses == "" {
+			addresses = row["OrAddresses"]
+		}
+		var exitAddresses []string
+		if addresses != "" {
+			exitAddresses = strings.Split(addresses, ",")
+		}
+		matches = append(matches, ExitMatch{row["Fingerprint"], row["Nickname"], exitAddresses, reason, row})
+	}
+	return matches, nil
+}
+
+func unmarshalIfSet(js string, v interface{}) error {
+	if js == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(js), v)
+}
+
+// Decodes the Onionoo details stored with a relay record (jsd)
+func Details(relay map[string]string) (onionoo.TorRelayDetails, error) {
+	var details onionoo.TorRelayDetails
+	err := json.Unmarshal([]byte(relay["jsd"]), &details)
+	return details, err
+}
+
+// Adds the records of extra whose relay (fpid) is not already present in ids
+func mergeByFingerprint(ids map[string]string, extra map[string]string) map[string]string {
+	seen := make(map[string]bool)
+	for _, fpid := range ids {
+		seen[fpid] = true
+	}
+	for id, fpid := range extra {
+		if !seen[fpid] {
+			ids[id] = fpid
+			seen[fpid] = true
+		}
+	}
+	return ids
+}
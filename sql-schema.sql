@@ -11,6 +11,23 @@ CREATE TABLE TorQueries (
 	PRIMARY KEY (ID)
 );
 
+CREATE TABLE NetworkSummaries (
+	ID_TorQueries INT UNSIGNED NOT NULL,
+	Relays INT UNSIGNED NOT NULL,
+	Running INT UNSIGNED NOT NULL,
+	Exits INT UNSIGNED NOT NULL,
+	Guards INT UNSIGNED NOT NULL,
+	AdvertisedBandwidth BIGINT UNSIGNED NOT NULL,
+	ExitAdvertisedBandwidth BIGINT UNSIGNED NOT NULL,
+	ConsensusWeight BIGINT UNSIGNED NOT NULL,
+	ExitConsensusWeight BIGINT UNSIGNED NOT NULL,
+	Flags JSON,
+	Countries JSON,
+	ASes JSON,
+	Versions JSON,
+	PRIMARY KEY (ID_TorQueries)
+);
+
 CREATE TABLE NodeFingerprints(
 	ID INT UNSIGNED AUTO_INCREMENT NOT NULL, 
 	Fingerprint CHAR(40) NOT NULL,
@@ -25,6 +42,13 @@ CREATE TABLE Countries (
 	UNIQUE(CountryName)
 );
 
+CREATE TABLE AutonomousSystems (
+	ASNumber INT UNSIGNED NOT NULL,
+	ASName VARCHAR(255) NOT NULL,
+	PRIMARY KEY (ASNumber),
+	INDEX(ASName)
+);
+
 CREATE TABLE Regions (
 	ID SMALLINT UNSIGNED AUTO_INCREMENT NOT NULL, 
 	RegionName CHAR(50),
@@ -57,7 +81,18 @@ CREATE TABLE Contacts (
 	ID SMALLINT UNSIGNED AUTO_INCREMENT NOT NULL, 
 	ContactName VARCHAR(3072) NOT NULL,
 	PRIMARY KEY (ID),
-	UNIQUE(ContactName)
+	UNIQUE(ContactName),
+	FULLTEXT INDEX contact_ft (ContactName)
+);
+
+CREATE TABLE ContactDetails (
+	ID INT UNSIGNED AUTO_INCREMENT NOT NULL, 
+	ID_Contacts SMALLINT UNSIGNED NOT NULL,
+	FieldType VARCHAR(16) NOT NULL,
+	FieldValue VARCHAR(255) NOT NULL,
+	PRIMARY KEY (ID),
+	INDEX(ID_Contacts),
+	INDEX field (FieldType, FieldValue)
 );
 
 CREATE TABLE ExitPolicies(
@@ -142,6 +177,61 @@ CREATE TABLE Dir_addresses_v6 (
 	INDEX(ip6) 
 );
 
+CREATE TABLE Unreachable_or_addresses_v4 (
+	ID INT UNSIGNED AUTO_INCREMENT NOT NULL, 
+	ID_NodeFingerprints INT UNSIGNED NOT NULL,
+	RecordTimeInserted TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	RecordLastSeen TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ip4 INT UNSIGNED NOT NULL,
+	port SMALLINT UNSIGNED NOT NULL,
+	PRIMARY KEY (ID),
+	INDEX(ip4),
+	INDEX(RecordLastSeen)
+);
+
+CREATE TABLE Unreachable_or_addresses_v6 (
+	ID INT UNSIGNED AUTO_INCREMENT NOT NULL, 
+	ID_NodeFingerprints INT UNSIGNED NOT NULL,
+	RecordTimeInserted TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	RecordLastSeen TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ip6 BINARY(16) NOT NULL,
+	port SMALLINT UNSIGNED NOT NULL,
+	PRIMARY KEY (ID),
+	INDEX(ip6),
+	INDEX(RecordLastSeen)
+);
+
+CREATE TABLE HostNames (
+	ID INT UNSIGNED AUTO_INCREMENT NOT NULL, 
+	HostName VARCHAR(255) NOT NULL,
+	ReversedHostName VARCHAR(255) NOT NULL,
+	PRIMARY KEY (ID),
+	UNIQUE(HostName),
+	INDEX(ReversedHostName)
+);
+
+CREATE TABLE Relay_host_names (
+	ID INT UNSIGNED AUTO_INCREMENT NOT NULL, 
+	ID_NodeFingerprints INT UNSIGNED NOT NULL,
+	ID_HostNames INT UNSIGNED NOT NULL,
+	Verified BOOLEAN NOT NULL,
+	RecordTimeInserted TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	RecordLastSeen TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (ID),
+	INDEX(ID_HostNames),
+	INDEX fp_time (ID_NodeFingerprints, RecordLastSeen)
+);
+
+CREATE TABLE PresenceIntervals (
+	ID INT UNSIGNED AUTO_INCREMENT NOT NULL, 
+	ID_NodeFingerprints INT UNSIGNED NOT NULL,
+	IntervalStart TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	IntervalEnd TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (ID),
+	INDEX fp_time (ID_NodeFingerprints, IntervalEnd),
+	INDEX(IntervalEnd)
+);
+
 CREATE TABLE TorRelays(
 	ID INT UNSIGNED AUTO_INCREMENT NOT NULL,
 	RecordTimeInserted TIMESTAMP NOT NULL,
@@ -151,9 +241,12 @@ CREATE TABLE TorRelays(
 	ID_Countries CHAR(2),
 	ID_Regions SMALLINT UNSIGNED,
 	ID_Cities SMALLINT UNSIGNED,
+	ID_AutonomousSystems INT UNSIGNED,
 
 	ID_Platforms SMALLINT UNSIGNED NOT NULL,
 	ID_Versions SMALLINT UNSIGNED NOT NULL,
+	Version_status CHAR(13),
+	Recommended_version BOOLEAN,
 	ID_Contacts SMALLINT UNSIGNED,
 	ID_ExitPolicies INT UNSIGNED NOT NULL,
 	ID_ExitPolicySummaries INT UNSIGNED NOT NULL,
@@ -163,24 +256,35 @@ CREATE TABLE TorRelays(
 	Last_changed_address_or_port DATETIME NOT NULL,
 	
 	First_seen DATETIME NOT NULL,
+	Latitude DECIMAL(9,6),
+	Longitude DECIMAL(9,6),
 	flags JSON,
 	jsd JSON,
 	PRIMARY KEY (ID),
-	INDEX fp_time (ID_NodeFingerprints, RecordLastSeen)
+	INDEX fp_time (ID_NodeFingerprints, RecordLastSeen),
+	INDEX geo (Latitude, Longitude),
+	INDEX as_time (ID_AutonomousSystems, RecordLastSeen),
+	INDEX platform_time (ID_Platforms, RecordLastSeen),
+	INDEX version_time (ID_Versions, RecordLastSeen),
+	INDEX contact_time (ID_Contacts, RecordLastSeen)
 );
 
 GRANT ALL PRIVILEGES ON tor_history.* TO 'tor-admin'@'%' IDENTIFIED BY <password> WITH GRANT OPTION;
 GRANT INSERT, DELETE, SELECT ON tor_history.TorQueries TO 'tor-rw'@'%' IDENTIFIED BY <password>;
 GRANT INSERT, DELETE, SELECT ON tor_history.TorQueries TO 'tor-rw'@'localhost' IDENTIFIED BY <password>;
+GRANT INSERT, SELECT ON tor_history.NetworkSummaries TO 'tor-rw'@'%';
+GRANT INSERT, SELECT ON tor_history.NetworkSummaries TO 'tor-rw'@'localhost';
 
 GRANT INSERT, SELECT ON tor_history.NodeFingerprints TO 'tor-rw'@'%';
 GRANT INSERT, UPDATE, SELECT ON tor_history.TorRelays TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.Countries TO 'tor-rw'@'%';
+GRANT INSERT, UPDATE, SELECT ON tor_history.AutonomousSystems TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.Regions TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.Cities TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.Platforms TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.Versions TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.Contacts TO 'tor-rw'@'%';
+GRANT INSERT, DELETE, SELECT ON tor_history.ContactDetails TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.ExitPolicies TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.ExitPolicySummaries TO 'tor-rw'@'%';
 GRANT INSERT, SELECT ON tor_history.ExitPolicyV6Summaries TO 'tor-rw'@'%';
@@ -191,16 +295,24 @@ GRANT INSERT, UPDATE, SELECT ON tor_history.Exit_addresses_v4 TO 'tor-rw'@'%';
 GRANT INSERT, UPDATE, SELECT ON tor_history.Exit_addresses_v6 TO 'tor-rw'@'%';
 GRANT INSERT, UPDATE, SELECT ON tor_history.Dir_addresses_v4 TO 'tor-rw'@'%';
 GRANT INSERT, UPDATE, SELECT ON tor_history.Dir_addresses_v6 TO 'tor-rw'@'%';
+GRANT INSERT, UPDATE, SELECT ON tor_history.Unreachable_or_addresses_v4 TO 'tor-rw'@'%';
+GRANT INSERT, UPDATE, SELECT ON tor_history.Unreachable_or_addresses_v6 TO 'tor-rw'@'%';
+
+GRANT INSERT, SELECT ON tor_history.HostNames TO 'tor-rw'@'%';
+GRANT INSERT, UPDATE, SELECT ON tor_history.Relay_host_names TO 'tor-rw'@'%';
+GRANT INSERT, UPDATE, DELETE, SELECT ON tor_history.PresenceIntervals TO 'tor-rw'@'%';
 
 -- Localhost user
 GRANT INSERT, SELECT ON tor_history.NodeFingerprints TO 'tor-rw'@'localhost';
 GRANT INSERT, UPDATE, SELECT ON tor_history.TorRelays TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.Countries TO 'tor-rw'@'localhost';
+GRANT INSERT, UPDATE, SELECT ON tor_history.AutonomousSystems TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.Regions TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.Cities TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.Platforms TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.Versions TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.Contacts TO 'tor-rw'@'localhost';
+GRANT INSERT, DELETE, SELECT ON tor_history.ContactDetails TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.ExitPolicies TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.ExitPolicySummaries TO 'tor-rw'@'localhost';
 GRANT INSERT, SELECT ON tor_history.ExitPolicyV6Summaries TO 'tor-rw'@'localhost';
@@ -211,3 +323,9 @@ GRANT INSERT, UPDATE, SELECT ON tor_history.Exit_addresses_v4 TO 'tor-rw'@'local
 GRANT INSERT, UPDATE, SELECT ON tor_history.Exit_addresses_v6 TO 'tor-rw'@'localhost';
 GRANT INSERT, UPDATE, SELECT ON tor_history.Dir_addresses_v4 TO 'tor-rw'@'localhost';
 GRANT INSERT, UPDATE, SELECT ON tor_history.Dir_addresses_v6 TO 'tor-rw'@'localhost';
+GRANT INSERT, UPDATE, SELECT ON tor_history.Unreachable_or_addresses_v4 TO 'tor-rw'@'localhost';
+GRANT INSERT, UPDATE, SELECT ON tor_history.Unreachable_or_addresses_v6 TO 'tor-rw'@'localhost';
+
+GRANT INSERT, SELECT ON tor_history.HostNames TO 'tor-rw'@'localhost';
+GRANT INSERT, UPDATE, SELECT ON tor_history.Relay_host_names TO 'tor-rw'@'localhost';
+GRANT INSERT, UPDATE, DELETE, SELECT ON tor_history.PresenceIntervals TO 'tor-rw'@'localhost';
@@ -0,0 +1,44 @@
+-- Upgrades an existing tor_history database to the current sql-schema.sql.
+-- New tables can be created with their CREATE TABLE statements from sql-schema.sql.
+-- "tor-history migrate" applies the missing tables and upgrades below (keep them in sync with store/migrate.go).
+USE tor_history;
+
+-- Geo location of the relays, backfilled from the stored details
+ALTER TABLE TorRelays
+	ADD COLUMN Latitude DECIMAL(9,6) AFTER First_seen,
+	ADD COLUMN Longitude DECIMAL(9,6) AFTER Latitude,
+	ADD INDEX geo (Latitude, Longitude);
+UPDATE TorRelays SET Latitude = jsd->>'$.Latitude', Longitude = jsd->>'$.Longitude'
+	WHERE jsd->>'$.Latitude' IS NOT NULL;
+
+-- Parsed contact details of the existing contacts, after creating the ContactDetails table:
+--   tor-history migrate -config-filename config.yml -parse-contacts
+
+-- Autonomous system of the relays, after creating the AutonomousSystems table.
+-- Backfilled from the stored details; As_number is the field name used before September 2018.
+ALTER TABLE TorRelays
+	ADD COLUMN ID_AutonomousSystems INT UNSIGNED AFTER ID_Cities,
+	ADD INDEX as_time (ID_AutonomousSystems, RecordLastSeen);
+UPDATE TorRelays SET ID_AutonomousSystems = CAST(SUBSTRING(COALESCE(jsd->>'$.As', jsd->>'$.As_number'), 3) AS UNSIGNED)
+	WHERE COALESCE(jsd->>'$.As', jsd->>'$.As_number') LIKE 'AS%';
+INSERT INTO AutonomousSystems (ASNumber, ASName)
+	SELECT ID_AutonomousSystems, MAX(jsd->>'$.As_name') FROM TorRelays
+	WHERE ID_AutonomousSystems IS NOT NULL AND jsd->>'$.As_name' IS NOT NULL GROUP BY ID_AutonomousSystems
+	ON DUPLICATE KEY UPDATE ASName = VALUES(ASName);
+
+-- Tor version status of the relays, backfilled from the stored details.
+-- Details stored before this change omit Recommended_version when false; it follows from
+-- Version_status for those.
+ALTER TABLE TorRelays
+	ADD COLUMN Version_status CHAR(13) AFTER ID_Versions,
+	ADD COLUMN Recommended_version BOOLEAN AFTER Version_status,
+	ADD INDEX platform_time (ID_Platforms, RecordLastSeen),
+	ADD INDEX version_time (ID_Versions, RecordLastSeen);
+UPDATE TorRelays SET Version_status = jsd->>'$.Version_status',
+	Recommended_version = CASE jsd->>'$.Recommended_version' WHEN 'true' THEN TRUE WHEN 'false' THEN FALSE END;
+UPDATE TorRelays SET Recommended_version = (Version_status = 'recommended')
+	WHERE Recommended_version IS NULL AND Version_status IS NOT NULL;
+
+-- Full-text search over the contacts
+ALTER TABLE Contacts ADD FULLTEXT INDEX contact_ft (ContactName);
+ALTER TABLE TorRelays ADD INDEX contact_time (ID_Contacts, RecordLastSeen);
@@ -0,0 +1,101 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+)
+
+// Parses an AS number as "AS1234", "as1234" or "1234". Returns the number in decimal.
+func ParseASNumber(as string) (string, bool) {
+	as = strings.TrimSpace(as)
+	if len(as) > 2 && strings.EqualFold(as[:2], "AS") {
+		as = as[2:]
+	}
+	n, err := strconv.ParseUint(as, 10, 32)
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatUint(n, 10), true
+}
+
+// Onionoo form of a stored AS number: "AS1234", or "" when there is none
+func asString(number string) string {
+	if number == "" {
+		return ""
+	}
+	return "AS" + number
+}
+
+// AS of the relay; documents before September 2018 have it in As_number
+func RelayAS(relay onionoo.TorRelayDetails) string {
+	if relay.As != "" {
+		return relay.As
+	}
+	return relay.As_number
+}
+
+// Returns the AS number to store in TorRelays (nil when the relay has none) and ensures
+// the AutonomousSystems table holds it, with the latest non empty name
+func (db *DB) NormalizeASNumber(as string, asName string) interface{} {
+	number, ok := ParseASNumber(as)
+	if !ok {
+		return nil
+	}
+	if name, cached := db.as2asNameMap[number]; !cached || (asName != "" && asName != name) {
+		if _, err := db.stmtAddAS.Exec(number, asName); err != nil {
+			panic("func NormalizeASNumber: " + err.Error())
+		}
+		db.as2asNameMap[number] = asName
+	}
+	return number
+}
+
+// AS numbers matching as, with their names: the number itself ("AS1234" or "1234"),
+// otherwise the ASes whose name contains it (case insensitive with the default collation)
+func (db *DB) ASNumbersByName(as string) map[string]string {
+	if number, ok := ParseASNumber(as); ok {
+		names := db.SQLQueryKeyValue("SELECT ASNumber, ASName FROM AutonomousSystems WHERE ASNumber = ?;", number)
+		if len(names) == 0 {
+			names[number] = ""
+		}
+		return names
+	}
+	as = strings.TrimSpace(as)
+	if len(as) == 0 {
+		return make(map[string]string)
+	}
+	return db.SQLQueryKeyValue("SELECT ASNumber, ASName FROM AutonomousSystems WHERE ASName LIKE ?;",
+		"%"+db.escapeLikeWildcards(as)+"%")
+}
+
+// Returns the intervals, overlapping the [from, to] time range, during which relays were
+// in the AS matching as (see ASNumbersByName). Consecutive records of a relay in the same
+// AS form a single interval. Each row holds ASNumber, ASName, Fingerprint,
+// RecordTimeInserted, RecordLastSeen and ID_TorRelays, the latest record of the interval.
+func (db *DB) GetASIntervals(as string, from string, to string) [](map[string]string) {
+	ifPrintln(3, "func GetASIntervals: "+as+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetASIntervals: END")
+
+	names := db.ASNumbersByName(as)
+	var numbers []interface{}
+	for number := range names {
+		numbers = append(numbers, number)
+	}
+
+	intervals := db.getRecordIntervals("ID_AutonomousSystems", numbers, from, to)
+	for _, i := range intervals {
+		i["ASNumber"] = i["ID_AutonomousSystems"]
+		i["ASName"] = names[i["ASNumber"]]
+		delete(i, "ID_AutonomousSystems")
+	}
+	return intervals
+}
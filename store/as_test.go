@@ -0,0 +1,23 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import "testing"
+
+func TestParseASNumber(t *testing.T) {
+	for in, want := range map[string]string{"AS1234": "1234", "as0042": "42", " 64512 ": "64512", "AS4294967295": "4294967295"} {
+		if got, ok := ParseASNumber(in); !ok || got != want {
+			t.Errorf("ParseASNumber(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "AS", "ASN1234", "AS4294967296", "AS-1", "Hetzner"} {
+		if got, ok := ParseASNumber(in); ok {
+			t.Errorf("ParseASNumber(%q) = %q, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// An invariant of the schema. count returns the number of rows violating it and
+// repair, if not nil, applies the safe fix returning the number of rows changed.
+type ConsistencyCheck struct {
+	description string
+	count       func(db *DB) int64
+	repair      func(db *DB) int64
+}
+
+// A table holding time intervals per fingerprint. Rows with the same key columns
+// describe the same record and must not overlap in time.
+type checkIntervalTable struct {
+	table, start, end string
+	key               []string
+	mergeable         bool // overlapping identical rows can be merged into one
+}
+
+func checkIntervalTables() []checkIntervalTable {
+	var tables []checkIntervalTable
+	for _, t := range historyAddressTables {
+		key := []string{"ID_NodeFingerprints", t.ipColumn}
+		if t.hasPort {
+			key = append(key, "port")
+		}
+		tables = append(tables, checkIntervalTable{t.table, "RecordTimeInserted", "RecordLastSeen", key, true})
+	}
+	return append(tables,
+		checkIntervalTable{"Relay_host_names", "RecordTimeInserted", "RecordLastSeen", []string{"ID_NodeFingerprints", "ID_HostNames", "Verified"}, true},
+		checkIntervalTable{"PresenceIntervals", "IntervalStart", "IntervalEnd", []string{"ID_NodeFingerprints"}, true},
+		// A relay has a single descriptor at a time, but the records differ so they are only reported
+		checkIntervalTable{"TorRelays", "RecordTimeInserted", "RecordLastSeen", []string{"ID_NodeFingerprints"}, false},
+	)
+}
+
+// Returns a func counting the rows returned by SELECT COUNT(*) query
+func checkCountQuery(query string) func(db *DB) int64 {
+	return func(db *DB) int64 {
+		var count int64
+		if err := db.dbh.QueryRow(query).Scan(&count); err != nil {
+			panic("func checkCountQuery: " + err.Error())
+		}
+		return count
+	}
+}
+
+// Returns a func executing the statement and returning the number of affected rows
+func checkExecQuery(query string) func(db *DB) int64 {
+	return func(db *DB) int64 {
+		return db.checkExec(query)
+	}
+}
+
+func (db *DB) checkExec(query string, args ...interface{}) int64 {
+	res, err := db.dbh.Exec(query, args...)
+	if err != nil {
+		panic("func checkExec: " + err.Error())
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		panic("func checkExec: " + err.Error())
+	}
+	return count
+}
+
+// Self join selecting pairs of identical rows with overlapping intervals
+func (t checkIntervalTable) overlapJoin(what string) string {
+	var on []string
+	for _, k := range t.key {
+		on = append(on, fmt.Sprintf("a.%s = b.%s", k, k))
+	}
+	return fmt.Sprintf("SELECT %s FROM %s a JOIN %s b ON %s AND a.ID < b.ID AND a.%s <= b.%s AND b.%s <= a.%s",
+		what, t.table, t.table, strings.Join(on, " AND "), t.start, t.end, t.start, t.end)
+}
+
+// Merges overlapping identical rows: the older row is extended to cover both
+// intervals and the newer one is removed. Repeats until no overlaps are left as
+// a row can overlap more than one other row.
+func (db *DB) mergeOverlappingIntervals(t checkIntervalTable) int64 {
+	ifPrintln(3, "func mergeOverlappingIntervals: "+t.table)
+	defer ifPrintln(3, "func mergeOverlappingIntervals: END")
+
+	var merged int64
+	for {
+		pairs := db.SQLQueryTYPEOfMaps("sliceOfMaps", t.overlapJoin("a.ID keepID, b.ID dropID")+" ORDER BY a.ID, b.ID").([](map[string]string))
+		if len(pairs) == 0 {
+			return merged
+		}
+		touched := make(map[string]bool)
+		for _, p := range pairs {
+			if touched[p["keepID"]] || touched[p["dropID"]] {
+				continue // Handled in the next pass
+			}
+			db.checkExec(fmt.Sprintf("UPDATE %s a JOIN %s b ON b.ID = ? SET a.%s = LEAST(a.%s, b.%s), a.%s = GREATEST(a.%s, b.%s) WHERE a.ID = ?",
+				t.table, t.table, t.start, t.start, t.start, t.end, t.end, t.end), p["dropID"], p["keepID"])
+			db.checkExec("DELETE FROM "+t.table+" WHERE ID = ?", p["dropID"])
+			touched[p["keepID"]] = true
+			touched[p["dropID"]] = true
+			merged++
+		}
+	}
+}
+
+func BuildConsistencyChecks() []ConsistencyCheck {
+	var checks []ConsistencyCheck
+
+	// Every fingerprint referenced by a relay record exists
+	checks = append(checks, ConsistencyCheck{
+		description: "TorRelays without a NodeFingerprints row",
+		count: checkCountQuery("SELECT COUNT(*) FROM TorRelays tr LEFT JOIN NodeFingerprints nf ON tr.ID_NodeFingerprints = nf.ID " +
+			"WHERE nf.ID IS NULL"),
+	})
+
+	// Every address (and other per fingerprint) row has a relay record for its fingerprint
+	for _, t := range checkIntervalTables() {
+		if t.table == "TorRelays" {
+			continue
+		}
+		checks = append(checks, ConsistencyCheck{
+			description: t.table + " rows without a TorRelays record",
+			count: checkCountQuery("SELECT COUNT(*) FROM " + t.table + " t WHERE NOT EXISTS " +
+				"(SELECT 1 FROM TorRelays tr WHERE tr.ID_NodeFingerprints = t.ID_NodeFingerprints)"),
+		})
+	}
+
+	// Last seen is never earlier than inserted
+	for _, t := range checkIntervalTables() {
+		checks = append(checks, ConsistencyCheck{
+			description: fmt.Sprintf("%s rows with %s < %s", t.table, t.end, t.start),
+			count:       checkCountQuery(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s < %s", t.table, t.end, t.start)),
+			repair:      checkExecQuery(fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s < %s", t.table, t.end, t.start, t.end, t.start)),
+		})
+	}
+
+	// Lookup IDs referenced from relay records exist
+	for _, lt := range pruneLookupTables {
+		checks = append(checks, ConsistencyCheck{
+			description: fmt.Sprintf("%s.%s referencing a missing %s row", lt.refTable, lt.refColumn, lt.table),
+			count: checkCountQuery(fmt.Sprintf("SELECT COUNT(*) FROM %s r LEFT JOIN %s l ON r.%s = l.ID WHERE r.%s IS NOT NULL AND l.ID IS NULL",
+				lt.refTable, lt.table, lt.refColumn, lt.refColumn)),
+		})
+	}
+	checks = append(checks, ConsistencyCheck{
+		description: "TorRelays.ID_Countries referencing a missing Countries row",
+		count: checkCountQuery("SELECT COUNT(*) FROM TorRelays r LEFT JOIN Countries l ON r.ID_Countries = l.CC " +
+			"WHERE r.ID_Countries IS NOT NULL AND l.CC IS NULL"),
+	})
+
+	// Lookup rows nobody references any longer, and the details (ContactDetails) of
+	// missing ones; the same rows prune -gc removes
+	for _, p := range BuildPrunePolicies(0, 0, true) {
+		checks = append(checks, ConsistencyCheck{
+			description: p.description,
+			count:       checkCountQuery("SELECT COUNT(*) FROM " + p.table + " WHERE " + p.where),
+			repair:      checkExecQuery("DELETE FROM " + p.table + " WHERE " + p.where),
+		})
+	}
+
+	// Identical records of a fingerprint do not overlap
+	for _, t := range checkIntervalTables() {
+		t := t
+		c := ConsistencyCheck{
+			description: "overlapping identical " + t.table + " rows",
+			count:       checkCountQuery(t.overlapJoin("COUNT(*)")),
+		}
+		if t.mergeable {
+			c.repair = func(db *DB) int64 { return db.mergeOverlappingIntervals(t) }
+		}
+		checks = append(checks, c)
+	}
+
+	return checks
+}
+
+// Runs the consistency checks, repairing what can be fixed safely if requested.
+// Returns the number of problems left.
+func (db *DB) Check(checks []ConsistencyCheck, repair bool) int64 {
+	ifPrintln(3, "func check: START")
+	defer ifPrintln(3, "func check: END")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	var left int64
+	for _, c := range checks {
+		count := c.count(db)
+		status := "OK"
+		if count > 0 {
+			status = "FAIL"
+			if repair && c.repair != nil {
+				fixed := c.repair(db)
+				count = c.count(db)
+				status = fmt.Sprintf("REPAIRED %d", fixed)
+			} else if c.repair == nil {
+				status = "FAIL (no automatic repair)"
+			}
+		}
+		fmt.Printf("%-75s %8d  %s\n", c.description, count, status)
+		left += count
+	}
+	return left
+}
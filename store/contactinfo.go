@@ -0,0 +1,190 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A structured field extracted from a relay ContactInfo string
+type ContactField struct {
+	Type  string // email, abuse, domain, url, pgp, proof, ciissversion
+	Value string
+}
+
+// Fields of the ContactInfo Information Sharing Specification (CIISS) we keep.
+// See https://nusenu.github.io/ContactInfo-Information-Sharing-Specification/
+var ciissFields = map[string]bool{
+	"email":        true,
+	"abuse":        true,
+	"url":          true,
+	"pgp":          true,
+	"proof":        true,
+	"ciissversion": true,
+}
+
+var (
+	ciissFieldRe = regexp.MustCompile(`(?i)^([a-z]+):(\S+)$`)
+
+	// [at] (at) {at} <at> [@] and the same for dot, with optional spaces
+	bracketAtRe  = regexp.MustCompile(`(?i)\s*[\[\(\{<]\s*(?:at|@)\s*[\]\)\}>]\s*`)
+	bracketDotRe = regexp.MustCompile(`(?i)\s*[\[\(\{<]\s*(?:dot|\.)\s*[\]\)\}>]\s*`)
+	// Upper case words are unambiguous: "name AT example DOT com"
+	wordAtRe  = regexp.MustCompile(`\s+AT\s+`)
+	wordDotRe = regexp.MustCompile(`\s+DOT\s+`)
+	// Lower case words only when they form an address: "name at example dot com"
+	lowerAtDotRe = regexp.MustCompile(`(?i)([\w.+-]+)\s+at\s+([\w-]+(?:\s+dot\s+[\w-]+)+)`)
+	lowerDotRe   = regexp.MustCompile(`(?i)\s+dot\s+`)
+
+	emailRe = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*\.[a-zA-Z]{2,}`)
+	urlRe   = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'\])]+`)
+	// 40 hex digits, optionally 0x prefixed and/or grouped by 4 with single spaces
+	pgpRe = regexp.MustCompile(`(?i)\b(?:0x)?((?:[0-9a-f]{4} ?){9}[0-9a-f]{4})\b`)
+)
+
+// Rewrites the usual e-mail obfuscations ([at], (dot), AT, DOT...) to plain "@" and "."
+func deobfuscateContact(contact string) string {
+	s := bracketAtRe.ReplaceAllString(contact, "@")
+	s = bracketDotRe.ReplaceAllString(s, ".")
+	s = wordAtRe.ReplaceAllString(s, "@")
+	s = wordDotRe.ReplaceAllString(s, ".")
+	s = lowerAtDotRe.ReplaceAllStringFunc(s, func(m string) string {
+		parts := lowerAtDotRe.FindStringSubmatch(m)
+		return parts[1] + "@" + lowerDotRe.ReplaceAllString(parts[2], ".")
+	})
+	return s
+}
+
+// Normalizes a PGP fingerprint to 40 upper case hex digits. Returns "" if it is not one.
+func normalizePGPFingerprint(fp string) string {
+	fp = strings.ToUpper(strings.Replace(strings.TrimSpace(fp), " ", "", -1))
+	fp = strings.TrimPrefix(fp, "0X")
+	if len(fp) != 40 || strings.Trim(fp, "0123456789ABCDEF") != "" {
+		return ""
+	}
+	return fp
+}
+
+// Host name of a URL without the "www." prefix
+func urlDomain(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(NormalizeHostName(u.Hostname()), "www.")
+}
+
+// Parses a ContactInfo string. CIISS fields are taken as they are, e-mail addresses,
+// URLs and PGP fingerprints are also extracted from free form (and obfuscated) text.
+// Domains of the e-mail addresses and URLs are added as "domain" fields.
+func ParseContactInfo(contact string) []ContactField {
+	fields := make(map[ContactField]bool)
+	add := func(fieldType string, value string) {
+		if value == "" {
+			return
+		}
+		fields[ContactField{fieldType, value}] = true
+		switch fieldType {
+		case "email", "abuse":
+			if at := strings.LastIndex(value, "@"); at >= 0 {
+				fields[ContactField{"domain", value[at+1:]}] = true
+			}
+		case "url":
+			if d := urlDomain(value); d != "" {
+				fields[ContactField{"domain", d}] = true
+			}
+		}
+	}
+
+	var freeForm []string
+	for _, token := range strings.Fields(contact) {
+		m := ciissFieldRe.FindStringSubmatch(token)
+		if m == nil || !ciissFields[strings.ToLower(m[1])] {
+			freeForm = append(freeForm, token)
+			continue
+		}
+		key, value := strings.ToLower(m[1]), m[2]
+		switch key {
+		case "email", "abuse":
+			// CIISS encodes "@" as "[]"
+			value = strings.Replace(value, "[]", "@", 1)
+			if email := emailRe.FindString(deobfuscateContact(value)); email != "" {
+				add(key, strings.ToLower(email))
+			}
+		case "url":
+			if !strings.Contains(value, "://") {
+				value = "https://" + value
+			}
+			add(key, value)
+		case "pgp":
+			add(key, normalizePGPFingerprint(value))
+		default:
+			add(key, strings.ToLower(value))
+		}
+	}
+
+	text := deobfuscateContact(strings.Join(freeForm, " "))
+	for _, u := range urlRe.FindAllString(text, -1) {
+		add("url", u)
+		text = strings.Replace(text, u, " ", 1) // Not an e-mail address source
+	}
+	for _, email := range emailRe.FindAllString(text, -1) {
+		add("email", strings.ToLower(email))
+	}
+	for _, m := range pgpRe.FindAllStringSubmatch(text, -1) {
+		add("pgp", normalizePGPFingerprint(m[1]))
+	}
+
+	result := make([]ContactField, 0, len(fields))
+	for f := range fields {
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Type != result[j].Type {
+			return result[i].Type < result[j].Type
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+// Stores the parsed fields of a contact. Values too long for the column are skipped.
+func (db *DB) addContactDetails(contactID string, contact string) {
+	ifPrintln(4, "func addContactDetails("+contactID+"): ")
+	for _, f := range ParseContactInfo(contact) {
+		if len(f.Value) > 255 {
+			ifPrintln(2, "addContactDetails: skipping long "+f.Type+" value of contact "+contactID)
+			continue
+		}
+		if _, err := db.stmtAddContactDetail.Exec(contactID, f.Type, f.Value); err != nil {
+			panic("func addContactDetails: " + err.Error())
+		}
+	}
+}
+
+// Re-parses every contact, replacing the stored details. Used to backfill contacts
+// imported before the details were parsed and after parser improvements.
+func (db *DB) ReparseContacts() int {
+	ifPrintln(3, "func ReparseContacts: START")
+	defer ifPrintln(3, "func ReparseContacts: END")
+
+	contacts := db.SQLQueryKeyValue("SELECT ID, ContactName FROM Contacts;")
+	for id, contact := range contacts {
+		if _, err := db.dbh.Exec("DELETE FROM ContactDetails WHERE ID_Contacts = ?", id); err != nil {
+			panic("func ReparseContacts: " + err.Error())
+		}
+		db.addContactDetails(id, contact)
+	}
+	return len(contacts)
+}
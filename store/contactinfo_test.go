@@ -0,0 +1,67 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeobfuscateContact(t *testing.T) {
+	for in, want := range map[string]string{
+		"tor AT example DOT org":             "tor@example.org",
+		"tor[at]example[dot]org":             "tor@example.org",
+		"tor [at] example [dot] org":         "tor@example.org",
+		"tor(at)example(dot)org":             "tor@example.org",
+		"tor {AT} mail {DOT} example.org":    "tor@mail.example.org",
+		"tor <@> example <.> org":            "tor@example.org",
+		"tor at example dot co dot uk":       "tor@example.co.uk",
+		"Random Person <tor at example.org>": "Random Person <tor at example.org>",
+		// Plain text: lower case "at" and "dot" which do not form an address
+		"run at home":                   "run at home",
+		"meet at noon, dot the i's":     "meet at noon, dot the i's",
+		"look at dot matrix printers":   "look at dot matrix printers",
+		"Operated at my data center":    "Operated at my data center",
+		"polka dot relay":               "polka dot relay",
+		"ATTENTION: no DOTS here, AT&T": "ATTENTION: no DOTS here, AT&T",
+	} {
+		if got := deobfuscateContact(in); got != want {
+			t.Errorf("deobfuscateContact(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseContactInfo(t *testing.T) {
+	const pgp = "0123456789ABCDEF0123456789ABCDEF01234567"
+	for _, tc := range []struct {
+		contact string
+		want    []ContactField
+	}{
+		{"email:tor[]example.org url:example.org proof:uri-rsa ciissversion:2", []ContactField{
+			{"ciissversion", "2"}, {"domain", "example.org"}, {"email", "tor@example.org"},
+			{"proof", "uri-rsa"}, {"url", "https://example.org"}}},
+		{"abuse:abuse[]example.net", []ContactField{{"abuse", "abuse@example.net"}, {"domain", "example.net"}}},
+		{"url:https://www.example.com/tor", []ContactField{{"domain", "example.com"}, {"url", "https://www.example.com/tor"}}},
+		{"pgp:0x0123456789abcdef0123456789abcdef01234567", []ContactField{{"pgp", pgp}}},
+		{"pgp:not-a-fingerprint", []ContactField{}},
+		{"Tor Admin <Admin AT Example DOT org>", []ContactField{{"domain", "example.org"}, {"email", "admin@example.org"}}},
+		{"tor[at]example[dot]org 0123 4567 89AB CDEF 0123 4567 89AB CDEF 0123", []ContactField{
+			{"domain", "example.org"}, {"email", "tor@example.org"}}},
+		{"tor[at]example[dot]org 0x0123 4567 89AB CDEF 0123 4567 89AB CDEF 0123 4567", []ContactField{
+			{"domain", "example.org"}, {"email", "tor@example.org"}, {"pgp", pgp}}},
+		{"see https://example.net/contact@page", []ContactField{{"domain", "example.net"}, {"url", "https://example.net/contact@page"}}},
+		// Plain text containing "at" and "dot"
+		{"Running at home, polka dot relay", []ContactField{}},
+		{"meet at noon, dot the i's", []ContactField{}},
+		{"", []ContactField{}},
+	} {
+		if got := ParseContactInfo(tc.contact); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseContactInfo(%q) = %v, want %v", tc.contact, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Shortest token indexed by the InnoDB full-text parser (innodb_ft_min_token_size)
+const ftMinTokenSize = 3
+
+// Most relevant contacts considered by a search
+const contactSearchLimit = inListChunkSize
+
+// InnoDB default full-text stopwords. They are not indexed, so requiring one would
+// match nothing; "com" and "www" are among them.
+var ftStopwords = map[string]bool{
+	"a": true, "about": true, "an": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"com": true, "de": true, "en": true, "for": true, "from": true, "how": true, "i": true, "in": true,
+	"is": true, "it": true, "la": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "where": true, "who": true,
+	"will": true, "with": true, "und": true, "www": true,
+}
+
+// Full-text (boolean mode) query for a contact search, and its kind:
+//
+//	email:  has an "@"; all the words of the address are required
+//	domain: has a "."; all the labels are required
+//	word:   all the words are required, as prefixes ("tor" matches "torservers")
+//
+// Only letters and digits reach the query, so the input cannot add operators.
+// Returns an empty query if no word can be searched for in the index.
+func ContactSearchQuery(text string) (kind string, query string) {
+	kind, suffix := "word", "*"
+	if strings.Contains(text, "@") {
+		kind, suffix = "email", ""
+	} else if strings.Contains(text, ".") {
+		kind, suffix = "domain", ""
+	}
+
+	var terms []string
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(token)) < ftMinTokenSize || ftStopwords[token] {
+			continue
+		}
+		terms = append(terms, "+"+token+suffix)
+	}
+	return kind, strings.Join(terms, " ")
+}
+
+// Contacts matching the search text, most relevant first, with the intervals each was
+// used by a relay within the [from, to] time range (see ContactSearchQuery). Each row
+// holds ContactName, Score, Fingerprint, RecordTimeInserted, RecordLastSeen and
+// ID_TorRelays, the latest record of the interval.
+func (db *DB) SearchContacts(text string, from string, to string) [](map[string]string) {
+	ifPrintln(3, "func SearchContacts: "+text+"; "+from+" - "+to)
+	defer ifPrintln(3, "func SearchContacts: END")
+
+	_, ftQuery := ContactSearchQuery(text)
+	if ftQuery == "" {
+		return make([](map[string]string), 0)
+	}
+	contacts := db.SQLQueryTYPEOfMaps("mapOfMaps", `SELECT ID, ContactName, MATCH(ContactName) AGAINST(? IN BOOLEAN MODE) Score
+		FROM Contacts WHERE MATCH(ContactName) AGAINST(? IN BOOLEAN MODE) ORDER BY Score DESC LIMIT ?;`,
+		ftQuery, ftQuery, contactSearchLimit).(map[string](map[string]string))
+
+	var ids []interface{}
+	for id := range contacts {
+		ids = append(ids, id)
+	}
+	intervals := db.getRecordIntervals("ID_Contacts", ids, from, to)
+	score := make(map[string]float64)
+	for _, i := range intervals {
+		contact := contacts[i["ID_Contacts"]]
+		i["ContactName"], i["Score"] = contact["ContactName"], contact["Score"]
+		score[i["ID_Contacts"]], _ = strconv.ParseFloat(contact["Score"], 64)
+	}
+
+	// Intervals are oldest first; keep that order within a contact
+	sort.SliceStable(intervals, func(i, j int) bool {
+		ci, cj := intervals[i]["ID_Contacts"], intervals[j]["ID_Contacts"]
+		if score[ci] != score[cj] {
+			return score[ci] > score[cj]
+		}
+		return ci < cj
+	})
+	return intervals
+}
@@ -0,0 +1,43 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContactSearchQuery(t *testing.T) {
+	for text, want := range map[string][2]string{
+		"Tor Relay Operators":                 {"word", "+tor* +relay* +operators*"},
+		"abuse@Example.org":                   {"email", "+abuse +example +org"},
+		"relays.torservers.net":               {"domain", "+relays +torservers +net"},
+		"www.example.com":                     {"domain", "+example"},
+		"ab cd":                               {"word", ""},
+		`"x" +y -z* (w) <v> ~u @9 operators"`: {"email", "+operators"},
+		"Ünïcödé 東京都":                         {"word", "+ünïcödé* +東京都*"},
+	} {
+		kind, query := ContactSearchQuery(text)
+		if kind != want[0] || query != want[1] {
+			t.Errorf("ContactSearchQuery(%q) = %q, %q; want %q, %q", text, kind, query, want[0], want[1])
+		}
+	}
+}
+
+func TestEmailLookupUsesTheFullTextIndex(t *testing.T) {
+	db := newRecordingDB(t)
+	db.GetLatestTRsIDsByEmail("abuse@example.org", MinTime, MaxTime)
+	queries := recordedQueries()
+	var matched bool
+	for _, q := range queries {
+		matched = matched || strings.Contains(q.query, "MATCH(ContactName)")
+	}
+	if !matched || !argsContain(queries, "+abuse +example +org") {
+		t.Errorf("the full-text index was not queried: %v", queries)
+	}
+}
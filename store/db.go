@@ -0,0 +1,1769 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+// Package store is the MySQL backed tor-history database: the schema specific
+// caches used during import and the lookup queries.
+package store
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/config"
+	"github.com/Harsh-bartariya/tor-history/logging"
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+var ifPrintln = logging.IfPrintln
+
+type DB struct {
+	initialized    bool
+	dbh            *sql.DB
+	stmtTorQueries *sql.Stmt
+
+	stmtAddNetworkSummary *sql.Stmt
+
+	stmtAddTorRelays        *sql.Stmt
+	stmtUpdTorRelaysRLS     *sql.Stmt
+	stmtLoadLatestTorRelays *sql.Stmt
+
+	// Caches
+	LRD map[string](map[string]string) // LRD = latest relay data, by fingerprint
+
+	fp2idMap     map[string]string
+	region2idMap map[string]string
+	city2idMap   map[string]string
+	cc2cyNameMap map[string]string
+	as2asNameMap map[string]string // AS number => AS name
+
+	platform2idMap map[string]string
+	version2idMap  map[string]string
+	contact2idMap  map[string]string
+
+	exitPol2idMap      map[string]string
+	exitPolSum2idMap   map[string]string
+	exitPolV6Sum2idMap map[string]string
+
+	hostName2idMap map[string]string
+
+	// Caches of last item inserted before a timestamp
+	latestOr4 map[string](map[string](map[string]string))
+	latestOr6 map[string](map[string](map[string]string))
+	latestEx4 map[string](map[string](map[string]string))
+	latestEx6 map[string](map[string](map[string]string))
+	latestDi4 map[string](map[string](map[string]string))
+	latestDi6 map[string](map[string](map[string]string))
+	latestUn4 map[string](map[string](map[string]string))
+	latestUn6 map[string](map[string](map[string]string))
+	latestHn  map[string](map[string](map[string]string))
+
+	// Cache related SQL statements
+	stmtAddNodeFingerprints *sql.Stmt
+	stmtAddCountryCode      *sql.Stmt
+	stmtAddAS               *sql.Stmt
+	stmtAddRegion           *sql.Stmt
+	stmtAddCity             *sql.Stmt
+	stmtAddPlatform         *sql.Stmt
+	stmtAddVersion          *sql.Stmt
+	stmtAddContact          *sql.Stmt
+	stmtAddContactDetail    *sql.Stmt
+	stmtAddHostName         *sql.Stmt
+
+	// Prepared SQL statements
+	stmtGetNodeIdByFp       *sql.Stmt
+	stmtGetRegionIdByName   *sql.Stmt
+	stmtGetCityIdByName     *sql.Stmt
+	stmtGetTorLastTorRelay  *sql.Stmt
+	stmtGetVersionIdByName  *sql.Stmt
+	stmtGetPlatformIdByName *sql.Stmt
+	stmtGetContactIdByName  *sql.Stmt
+	stmtGetHostNameIdByName *sql.Stmt
+
+	stmtAddExitPolicy                  *sql.Stmt
+	stmtGetExitPolicyIdByName          *sql.Stmt
+	stmtAddExitPolicySummary           *sql.Stmt
+	stmtGetExitPolicySummaryIdByName   *sql.Stmt
+	stmtAddExitPolicyV6Summary         *sql.Stmt
+	stmtGetExitPolicyV6SummaryIdByName *sql.Stmt
+
+	stmtAddOrV4   *sql.Stmt
+	stmtAddExitV4 *sql.Stmt
+	stmtAddDirV4  *sql.Stmt
+	stmtAddOrV6   *sql.Stmt
+	stmtAddExitV6 *sql.Stmt
+	stmtAddDirV6  *sql.Stmt
+	stmtAddUnV4   *sql.Stmt
+	stmtAddUnV6   *sql.Stmt
+
+	stmtUpdOr4RLS *sql.Stmt
+	stmtUpdEx4RLS *sql.Stmt
+	stmtUpdDi4RLS *sql.Stmt
+	stmtUpdOr6RLS *sql.Stmt
+	stmtUpdEx6RLS *sql.Stmt
+	stmtUpdDi6RLS *sql.Stmt
+	stmtUpdUn4RLS *sql.Stmt
+	stmtUpdUn6RLS *sql.Stmt
+
+	stmtAddRelayHostName    *sql.Stmt
+	stmtUpdRelayHostNameRLS *sql.Stmt
+
+	stmtAddPresence      *sql.Stmt
+	stmtUpdPresenceStart *sql.Stmt
+	stmtUpdPresenceEnd   *sql.Stmt
+	stmtDelPresence      *sql.Stmt
+}
+
+//***************************************************************************
+// Open/Initialize/Close functions
+
+// Factory creating a new DB
+// Construct connection string from tokens and execute "Open()"
+// Initialize prepared statements
+func NewDB(Username string, Password string, Host string, Port string, DBName string) *DB {
+	ifPrintln(1, "Initializing database...")
+	defer ifPrintln(1, "Database Ready.")
+	var db DB
+	var err error
+
+	db.dbh, err = sql.Open("mysql", connString(Username, Password, Host, Port, DBName))
+	if err != nil {
+		panic("func NewDB: " + err.Error())
+	}
+
+	// Prepare various SQL queries
+	SQLStatements := map[string]**sql.Stmt{
+		"INSERT INTO Countries (CC, CountryName) VALUES( ?, ?)":                                                           &db.stmtAddCountryCode,
+		"INSERT INTO TorQueries (Version, Relays_published, Bridges_published, AcquisitionTimestamp) VALUES( ?, ?, ?, ?)": &db.stmtTorQueries,
+
+		"INSERT INTO AutonomousSystems (ASNumber, ASName) VALUES(?, ?) ON DUPLICATE KEY UPDATE ASName = VALUES(ASName)": &db.stmtAddAS,
+
+		"INSERT INTO TorRelays (ID_NodeFingerprints, ID_Countries, ID_Regions, ID_Cities, ID_AutonomousSystems, ID_Platforms, ID_Versions, Version_status, Recommended_version, ID_Contacts, " +
+			"ID_ExitPolicies, ID_ExitPolicySummaries, ID_ExitPolicyV6Summaries, Nickname, Last_changed_address_or_port, First_seen, RecordTimeInserted, RecordLastSeen, " +
+			"Latitude, Longitude, flags, jsd) " +
+			"VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);": &db.stmtAddTorRelays,
+
+		"UPDATE TorRelays SET RecordLastSeen = ? WHERE ID = ?;": &db.stmtUpdTorRelaysRLS,
+
+		"INSERT INTO NetworkSummaries (ID_TorQueries, Relays, Running, Exits, Guards, AdvertisedBandwidth, ExitAdvertisedBandwidth, " +
+			"ConsensusWeight, ExitConsensusWeight, Flags, Countries, ASes, Versions) " +
+			"VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);": &db.stmtAddNetworkSummary,
+
+		"INSERT INTO NodeFingerprints (Fingerprint) VALUES( ?)":  &db.stmtAddNodeFingerprints,
+		"SELECT ID FROM NodeFingerprints WHERE Fingerprint = ?;": &db.stmtGetNodeIdByFp,
+		"INSERT INTO Cities (CityName) VALUES( ?)":               &db.stmtAddCity,
+		"SELECT ID FROM Cities WHERE CityName = ?;":              &db.stmtGetCityIdByName,
+		"INSERT INTO Regions (RegionName) VALUES( ?)":            &db.stmtAddRegion,
+		"SELECT ID FROM Regions WHERE RegionName = ?;":           &db.stmtGetRegionIdByName,
+
+		"INSERT INTO Platforms (PlatformName) VALUES( ?)":  &db.stmtAddPlatform,
+		"SELECT ID FROM Platforms WHERE PlatformName = ?;": &db.stmtGetPlatformIdByName,
+		"INSERT INTO Versions (VersionName) VALUES( ?)":    &db.stmtAddVersion,
+		"SELECT ID FROM Versions WHERE VersionName = ?;":   &db.stmtGetVersionIdByName,
+		"INSERT INTO Contacts (ContactName) VALUES( ?)":    &db.stmtAddContact,
+		"SELECT ID FROM Contacts WHERE ContactName = ?;":   &db.stmtGetContactIdByName,
+
+		"INSERT INTO ExitPolicies (ExitPolicy) VALUES( ?)":                    &db.stmtAddExitPolicy,
+		"SELECT ID FROM ExitPolicies WHERE ExitPolicy = ?;":                   &db.stmtGetExitPolicyIdByName,
+		"INSERT INTO ExitPolicySummaries (ExitPolicySummary) VALUES( ?)":      &db.stmtAddExitPolicySummary,
+		"SELECT ID FROM ExitPolicySummaries WHERE ExitPolicySummary = ?;":     &db.stmtGetExitPolicySummaryIdByName,
+		"INSERT INTO ExitPolicyV6Summaries (ExitPolicyV6Summary) VALUES( ?)":  &db.stmtAddExitPolicyV6Summary,
+		"SELECT ID FROM ExitPolicyV6Summaries WHERE ExitPolicyV6Summary = ?;": &db.stmtGetExitPolicyV6SummaryIdByName,
+
+		"INSERT INTO Or_addresses_v4 (ID_NodeFingerprints, RecordTimeInserted, RecordLastSeen, ip4, port) VALUES(?, ?, ?, INET_ATON(?), ?)":  &db.stmtAddOrV4,
+		"INSERT INTO Exit_addresses_v4 (ID_NodeFingerprints, RecordTimeInserted, RecordLastSeen, ip4) VALUES(?, ?, ?, INET_ATON(?))":         &db.stmtAddExitV4,
+		"INSERT INTO Dir_addresses_v4 (ID_NodeFingerprints, RecordTimeInserted, RecordLastSeen, ip4, port) VALUES(?, ?, ?, INET_ATON(?), ?)": &db.stmtAddDirV4,
+
+		"INSERT INTO Or_addresses_v6 (ID_NodeFingerprints, RecordTimeInserted, RecordLastSeen, ip6, port) VALUES(?, ?, ?, INET6_ATON(?), ?)":  &db.stmtAddOrV6,
+		"INSERT INTO Exit_addresses_v6 (ID_NodeFingerprints, RecordTimeInserted, RecordLastSeen, ip6) VALUES(?, ?, ?, INET6_ATON(?))":         &db.stmtAddExitV6,
+		"INSERT INTO Dir_addresses_v6 (ID_NodeFingerprints, RecordTimeInserted, RecordLastSeen, ip6, port) VALUES(?, ?, ?, INET6_ATON(?), ?)": &db.stmtAddDirV6,
+
+		"UPDATE Or_addresses_v4 SET RecordLastSeen=? WHERE ID = ?":   &db.stmtUpdOr4RLS,
+		"UPDATE Exit_addresses_v4 SET RecordLastSeen=? WHERE ID = ?": &db.stmtUpdEx4RLS,
+		"UPDATE Dir_addresses_v4 SET RecordLastSeen=? WHERE ID = ?":  &db.stmtUpdDi4RLS,
+
+		"UPDATE Or_addresses_v6 SET RecordLastSeen=? WHERE ID = ?;":   &db.stmtUpdOr6RLS,
+		"UPDATE Exit_addresses_v6 SET RecordLastSeen=? WHERE ID = ?;": &db.stmtUpdEx6RLS,
+		"UPDATE Dir_addresses_v6 SET RecordLastSeen=? WHERE ID = ?;":  &db.stmtUpdDi6RLS,
+
+		"INSERT INTO Unreachable_or_addresses_v4 (ID_NodeFingerprints, RecordTimeInserted, RecordLastSeen, ip4, port) VALUES(?, ?, ?, INET_ATON(?), ?)":  &db.stmtAddUnV4,
+		"INSERT INTO Unreachable_or_addresses_v6 (ID_NodeFingerprints, RecordTimeInserted, RecordLastSeen, ip6, port) VALUES(?, ?, ?, INET6_ATON(?), ?)": &db.stmtAddUnV6,
+		"UPDATE Unreachable_or_addresses_v4 SET RecordLastSeen=? WHERE ID = ?;":                                                                          &db.stmtUpdUn4RLS,
+		"UPDATE Unreachable_or_addresses_v6 SET RecordLastSeen=? WHERE ID = ?;":                                                                          &db.stmtUpdUn6RLS,
+
+		"INSERT INTO ContactDetails (ID_Contacts, FieldType, FieldValue) VALUES(?, ?, ?)": &db.stmtAddContactDetail,
+
+		"INSERT INTO HostNames (HostName, ReversedHostName) VALUES( ?, ?)": &db.stmtAddHostName,
+		"SELECT ID FROM HostNames WHERE HostName = ?;":                     &db.stmtGetHostNameIdByName,
+
+		"INSERT INTO Relay_host_names (ID_NodeFingerprints, ID_HostNames, Verified, RecordTimeInserted, RecordLastSeen) VALUES(?, ?, ?, ?, ?)": &db.stmtAddRelayHostName,
+
+		"UPDATE Relay_host_names SET RecordLastSeen=? WHERE ID = ?;": &db.stmtUpdRelayHostNameRLS,
+
+		"INSERT INTO PresenceIntervals (ID_NodeFingerprints, IntervalStart, IntervalEnd) VALUES(?, ?, ?)": &db.stmtAddPresence,
+		"UPDATE PresenceIntervals SET IntervalStart=? WHERE ID = ?;":                                      &db.stmtUpdPresenceStart,
+		"UPDATE PresenceIntervals SET IntervalEnd=? WHERE ID = ?;":                                        &db.stmtUpdPresenceEnd,
+		"DELETE FROM PresenceIntervals WHERE ID = ?;":                                                     &db.stmtDelPresence,
+	}
+
+	for stmt, storage := range SQLStatements {
+		*storage, err = db.dbh.Prepare(stmt)
+		if err != nil {
+			fmt.Println("PANIC: SQL Statement: " + stmt)
+			panic("func NewDB: for: " + err.Error())
+		}
+	}
+
+	db.initialized = true
+	return &db
+}
+
+// Take Config object and convert it in a way consumable for the previous NewDB
+func NewDBFromConfig(cfg config.TorHistoryConfig) *DB {
+	return NewDB(cfg.DBServer.Username, cfg.DBServer.Password, cfg.DBServer.Host, cfg.DBServer.Port, cfg.DBServer.DBName)
+}
+
+// MySQL data source name
+func connString(Username string, Password string, Host string, Port string, DBName string) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", Username, Password, Host, Port, DBName)
+}
+
+/*
+func IPtoRelayIDs(type string, ip46 string) []TorRelayDetails{
+// Given IP address and function (Or, Ex, Di), produces a list of nodes which occupied used that IP for that function
+// Since multiple relays may have used that IP it returns a list of TorRelay Nodes.
+// Maybe collect the time periods?
+	switch type {
+	case "Or":
+		break
+	}
+
+}
+*/
+
+/*
+func assembleRecordByIPID (Or, Ex, Di){
+	// Detect v4/v6
+	// Add time fram limitations
+	switch type {
+	case "Or":
+		"SELECT o4.ID, o4.ID_NodeFingerprints FROM Or_addresses_v4 o4 LEFT JOIN TorRelays tr ON o4.ID_NodeFingerprints = tr.ID_NodeFingerprints WHERE o4.ID = ?;", qID);
+		break
+	case "Ex":
+		break
+	case "Di":
+		break
+
+	}
+}
+
+func assembleRecordByTorRelayID(id uint) *TorRelayDetails {
+
+	if !db.initialized {
+		log.Fatal("Call to a method in uninitialized database initializeLatestRelayDataCache.")
+	}
+	*lrd = g_db.SQLQueryTYPEOfMaps("mapOfMaps",
+		`SELECT Fingerprint, tr.ID id, Nickname, RecordTimeInserted, DATE_FORMAT( RecordLastSeen, "%Y%m%d%H%i%s") as RecordLastSeen,
+			ID_Countries Country, CityName, PlatformName, VersionName, ContactName, First_seen, Last_changed_address_or_port,
+			ExitPolicy, ExitPolicySummary, ExitPolicyV6Summary, tr.ID_Versions, tr.ID_Contacts, ID_NodeFingerprints
+			FROM TorRelays tr
+			LEFT JOIN NodeFingerprints nf ON tr.ID_NodeFingerprints = nf.ID
+			LEFT JOIN Cities c ON ID_Cities = c.ID
+			LEFT JOIN Platforms p ON ID_Platforms = p.ID
+			LEFT JOIN Versions v ON ID_Versions = v.ID
+			LEFT JOIN Contacts ct ON ID_Contacts = ct.ID
+			LEFT JOIN ExitPolicies ep ON ID_ExitPolicies = ep.ID
+			LEFT JOIN ExitPolicySummaries eps ON ID_ExitPolicySummaries = eps.ID
+			LEFT JOIN ExitPolicyV6Summaries eps6 ON ID_ExitPolicyV6Summaries = eps6.ID
+			WHERE (ID_NodeFingerprints, RecordLastSeen) IN
+			(SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM TorRelays WHERE RecordLastSeen <= "`+cdts+`" GROUP BY ID_NodeFingerprints);`).(map[string](map[string]string))
+
+}
+*/
+func (db *DB) InitializeLatestRelayDataCache(lrd *map[string](map[string]string), cdts string) { // cdts generally is the consensus download timestamp (DLTS)
+	ifPrintln(3, "Initializing Latest Relay Data (LRD) cache...")
+	defer ifPrintln(3, "Latest Relay Data (LRD) cache ready.")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database InitializeLatestRelayDataCache.")
+	}
+	*lrd = db.SQLQueryTYPEOfMaps("mapOfMaps",
+		`SELECT Fingerprint, tr.ID id, Nickname, RecordTimeInserted, DATE_FORMAT( RecordLastSeen, "%Y%m%d%H%i%s") as RecordLastSeen, 
+			ID_Countries Country, CityName, PlatformName, VersionName, ContactName, First_seen, Last_changed_address_or_port, 
+			ExitPolicy, ExitPolicySummary, ExitPolicyV6Summary, tr.ID_Versions, tr.ID_Contacts, ID_NodeFingerprints, Latitude, Longitude,
+			ID_AutonomousSystems, Version_status, Recommended_version
+			FROM TorRelays tr
+			LEFT JOIN NodeFingerprints nf ON tr.ID_NodeFingerprints = nf.ID 
+			LEFT JOIN Cities c ON ID_Cities = c.ID
+			LEFT JOIN Platforms p ON ID_Platforms = p.ID
+			LEFT JOIN Versions v ON ID_Versions = v.ID
+			LEFT JOIN Contacts ct ON ID_Contacts = ct.ID
+			LEFT JOIN ExitPolicies ep ON ID_ExitPolicies = ep.ID
+			LEFT JOIN ExitPolicySummaries eps ON ID_ExitPolicySummaries = eps.ID
+			LEFT JOIN ExitPolicyV6Summaries eps6 ON ID_ExitPolicyV6Summaries = eps6.ID
+			WHERE (ID_NodeFingerprints, RecordLastSeen) IN 
+			(SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM TorRelays WHERE RecordLastSeen <= ? GROUP BY ID_NodeFingerprints);`, cdts).(map[string](map[string]string))
+}
+
+// Loads the lookup caches and the latest address/host name records seen at or before dlts
+func (db *DB) InitCaches(dlts string) {
+	ifPrintln(3, "InitCaches: Initialiazing memory caches from database...")
+	defer ifPrintln(3, "Caches ready.")
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database" + "InitCaches" + ".")
+	}
+	db.fp2idMap = db.SQLQueryKeyValue("SELECT Fingerprint, ID FROM NodeFingerprints;")
+	db.region2idMap = db.SQLQueryKeyValue("SELECT RegionName, ID FROM Regions;")
+	db.city2idMap = db.SQLQueryKeyValue("SELECT CityName, ID FROM Cities;")
+	db.platform2idMap = db.SQLQueryKeyValue("SELECT PlatformName, ID FROM Platforms;")
+	db.version2idMap = db.SQLQueryKeyValue("SELECT VersionName, ID FROM Versions;")
+	db.contact2idMap = db.SQLQueryKeyValue("SELECT ContactName, ID FROM Contacts;")
+
+	db.exitPol2idMap = db.SQLQueryKeyValue("SELECT ExitPolicy, ID FROM ExitPolicies;")
+	db.exitPolSum2idMap = db.SQLQueryKeyValue("SELECT ExitPolicySummary, ID FROM ExitPolicySummaries;")
+	db.exitPolV6Sum2idMap = db.SQLQueryKeyValue("SELECT ExitPolicyV6Summary, ID FROM ExitPolicyV6Summaries;")
+	db.hostName2idMap = db.SQLQueryKeyValue("SELECT HostName, ID FROM HostNames;")
+	db.as2asNameMap = db.SQLQueryKeyValue("SELECT ASNumber, ASName FROM AutonomousSystems;")
+
+	// Load latest records BEFORE the current insert timestamp. Note this allows us to insert older data files (retroactively)
+	db.latestOr4 = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, INET_NTOA(ip4) ip4, ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen, port "+
+		"FROM Or_addresses_v4 WHERE (ID_NodeFingerprints, RecordLastSeen) IN "+
+		"(SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM Or_addresses_v4 WHERE RecordLastSeen <= ? "+
+		"GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+
+	db.latestOr6 = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, INET6_NTOA(ip6) ip6, ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen, port FROM Or_addresses_v6 "+
+		"WHERE (ID_NodeFingerprints, RecordLastSeen) IN (SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM Or_addresses_v6 "+
+		"WHERE RecordLastSeen <= ? GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+
+	db.latestEx4 = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, INET_NTOA(ip4) ip4, ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen FROM Exit_addresses_v4 "+
+		"WHERE (ID_NodeFingerprints, RecordLastSeen) IN (SELECT ID_NodeFingerprints, max(RecordLastSeen) "+
+		"FROM Exit_addresses_v4 WHERE RecordLastSeen <= ? GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+
+	db.latestEx6 = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, INET6_NTOA(ip6) ip6, ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen FROM Exit_addresses_v6 "+
+		"WHERE (ID_NodeFingerprints, RecordLastSeen) IN (SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM Exit_addresses_v6 "+
+		"WHERE RecordLastSeen <= ? GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+
+	db.latestDi4 = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, INET_NTOA(ip4) ip4, ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen, port FROM Dir_addresses_v4 "+
+		"WHERE (ID_NodeFingerprints, RecordLastSeen) IN (SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM Dir_addresses_v4 "+
+		"WHERE RecordLastSeen <= ? GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+
+	db.latestDi6 = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, INET6_NTOA(ip6) ip6, ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen, port FROM Dir_addresses_v6 "+
+		"WHERE (ID_NodeFingerprints, RecordLastSeen) IN (SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM Dir_addresses_v6 "+
+		"WHERE RecordLastSeen <= ? GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+
+	db.latestUn4 = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, INET_NTOA(ip4) ip4, ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen, port FROM Unreachable_or_addresses_v4 "+
+		"WHERE (ID_NodeFingerprints, RecordLastSeen) IN (SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM Unreachable_or_addresses_v4 "+
+		"WHERE RecordLastSeen <= ? GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+
+	db.latestUn6 = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, INET6_NTOA(ip6) ip6, ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen, port FROM Unreachable_or_addresses_v6 "+
+		"WHERE (ID_NodeFingerprints, RecordLastSeen) IN (SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM Unreachable_or_addresses_v6 "+
+		"WHERE RecordLastSeen <= ? GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+
+	db.latestHn = db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT rh.ID_NodeFingerprints, HostName, rh.ID, DATE_FORMAT( RecordLastSeen, '%Y%m%d%H%i%s') as RecordLastSeen, Verified "+
+		"FROM Relay_host_names rh LEFT JOIN HostNames h ON rh.ID_HostNames = h.ID WHERE (rh.ID_NodeFingerprints, RecordLastSeen) IN "+
+		"(SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM Relay_host_names WHERE RecordLastSeen <= ? "+
+		"GROUP BY ID_NodeFingerprints);", dlts).(map[string](map[string](map[string]string)))
+	ifPrintln(2, "InitCaches: Caches initialized")
+}
+
+func (db *DB) InitCountryNameCache() {
+	ifPrintln(3, "Initializing Countryname cache...")
+	defer ifPrintln(3, "Countryname cache ready.")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database" + "InitCountryNameCache" + ".")
+	}
+	ifPrintln(2, "InitCountryNameCache: Initialiazing memocountry codes cache from database")
+	db.cc2cyNameMap = db.SQLQueryKeyValue("SELECT LOWER(CC) CC, CountryName FROM Countries;") // Uses LOWER() just in case the database was initialized with capital CC
+}
+
+// True once the connection is open and the statements are prepared
+func (db *DB) Initialized() bool {
+	return db != nil && db.initialized
+}
+
+func (db *DB) Close() {
+	if db == nil || !db.Initialized() {
+		return
+	}
+	ifPrintln(8, "Database shutdown")
+	ifPrintln(4, "Closing statement and connection.")
+	db.dbh.Close()
+	db.stmtTorQueries.Close()
+}
+
+//***************************************************************************
+// SQL query functions
+
+// Executes an arbitrary SQL query which return two columns and returns a map
+// where the first column is the key and second the value. Optional args are
+// bound to the placeholders in the query.
+func (db *DB) SQLQueryKeyValue(query string, args ...interface{}) map[string]string {
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database" + "SQLQueryKeyValue" + ".")
+	}
+	ifPrintln(5, "SQLQueryKeyValue("+db.escapePercentSign(query)+"): ")
+	rows, err := db.dbh.Query(query, args...)
+	if err != nil {
+		panic("func SQLQueryKeyValue: " + err.Error())
+	}
+
+	// Verify the return column count
+	columns, err := rows.Columns()
+	if err != nil || len(columns) != 2 {
+		panic("func SQLQueryKeyValue: " + fmt.Sprintf("ERROR: Columns returned %d.\n", len(columns)) + err.Error())
+	}
+
+	// Allocate storage for the result, returned to the caller
+	var resultMap = make(map[string]string)
+
+	// Buffer variables
+	var key, val string
+
+	for rows.Next() {
+		err = rows.Scan(&key, &val)
+		if err != nil {
+			panic("func SQLQueryKeyValue: " + err.Error())
+		}
+		resultMap[key] = val
+	}
+	ifPrintln(5, "func SQLQueryKeyValue RETURN a map (not expanded)")
+	return resultMap
+}
+
+// Returs the query as a map or slice of maps, depending on the TYPE argument
+// The key for the outer map is the first element in the SELECT query
+// The inner maps contain the full record, including the first element
+// Optional args are bound to the placeholders in the query
+// TYPE one of:
+//		sliceOfMaps:	[](map[string]string)
+//		mapOfMaps:		map[string](map[string]string)
+//		mapOfMapOfMaps: map[string](map[string](map[string]string))
+//		sliceOfSlice
+func (db *DB) SQLQueryTYPEOfMaps(TYPE string, query string, args ...interface{}) interface{} {
+	ifPrintln(5, "func SQLQueryTYPEOfMaps: ("+TYPE+", \n"+db.escapePercentSign(query)+"):")
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	if TYPE != "sliceOfMaps" && TYPE != "mapOfMaps" && TYPE != "mapOfMapOfMaps" && TYPE != "sliceOfSlice" {
+		panic("SQLQueryTYPEOfMaps: Supplied TYPE='" + TYPE + "' TYPE can be only one of the following: sliceOfMaps, mapOfMapOfMaps, mapOfMaps, sliceOfSlice")
+	}
+
+	rows, err := db.dbh.Query(query, args...)
+	if err != nil {
+		panic("func SQLQueryTYPEOfMaps: " + err.Error())
+	}
+
+	// Figure out how many columns are in the response
+	columns, err := rows.Columns()
+	if err != nil {
+		panic("func SQLQueryTYPEOfMaps: " + err.Error()) // TODO
+	}
+	ifPrintln(6, fmt.Sprintf("Number of columns returned: %d", len(columns)))
+
+	// Allocate row buffer for each column of type sql.RawBytes
+	// Data from rows.scan will be stored there
+	rowBuffer := make([]sql.RawBytes, len(columns))
+
+	// Allocate column number of pointers to point to each of the buffers from above
+	// This is what we'll pass to rows.scan()
+	rowBufferPtrs := make([]interface{}, len(rowBuffer))
+	for i := range rowBuffer {
+		rowBufferPtrs[i] = &rowBuffer[i]
+	}
+
+	// Return result buffers
+	result_slice := make([](map[string]string), 0)
+	result_map := make(map[string](map[string]string))
+	result_map_map := make(map[string](map[string](map[string]string)))
+
+	var result_row map[string]string
+	for rows.Next() {
+		err = rows.Scan(rowBufferPtrs...)
+		if err != nil {
+			panic("func SQLQueryTYPEOfMaps: " + err.Error())
+		}
+		result_row = make(map[string]string)
+		for i := 0; i < len(columns); i++ {
+			result_row[columns[i]] = string(rowBuffer[i])
+		}
+		if TYPE == "sliceOfMaps" {
+			result_slice = append(result_slice, result_row)
+		} else if TYPE == "mapOfMaps" {
+			result_map[string(rowBuffer[0])] = result_row
+		} else if TYPE == "mapOfMapOfMaps" { // double nested map
+			if result_map_map[string(rowBuffer[0])] == nil {
+				// Allocating memory for sub-maps for each of the main keys
+				result_map_map[string(rowBuffer[0])] = make(map[string](map[string]string))
+			}
+			result_map_map[string(rowBuffer[0])][string(rowBuffer[1])] = result_row
+		} else { // later: TYPE != "sliceOfSlice"
+			panic("func SQLQueryTYPEOfMaps() default case")
+		}
+	}
+
+	if TYPE == "sliceOfMaps" {
+		return result_slice
+	} else if TYPE == "mapOfMaps" {
+		return result_map
+	} else if TYPE == "mapOfMapOfMaps" {
+		return result_map_map
+	} else { // later: TYPE != "sliceOfSlice"
+		return nil
+	}
+}
+
+// Generic function which gets the ID column from one of the caches/indexes by its value
+func (db *DB) dbGetKeyByValue(valueType string, value string) string {
+	ifPrintln(6, "func dbGetKeyByValue("+valueType+"): "+value)
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	var err error
+	var row *sql.Rows
+
+	switch valueType {
+	case "fingerprint":
+		row, err = db.stmtGetNodeIdByFp.Query(value)
+		//	case "country":
+		//		res, err = db.stmtGetNodeIdByFp.Query(value)
+	case "region":
+		row, err = db.stmtGetRegionIdByName.Query(value)
+	case "city":
+		row, err = db.stmtGetCityIdByName.Query(value)
+	case "platform":
+		row, err = db.stmtGetPlatformIdByName.Query(value)
+	case "version":
+		row, err = db.stmtGetVersionIdByName.Query(value)
+	case "contact":
+		row, err = db.stmtGetContactIdByName.Query(value)
+	case "exitp":
+		row, err = db.stmtGetExitPolicyIdByName.Query(value)
+	case "exitps":
+		row, err = db.stmtGetExitPolicySummaryIdByName.Query(value)
+	case "exitps6":
+		row, err = db.stmtGetExitPolicyV6SummaryIdByName.Query(value)
+	case "hostname":
+		row, err = db.stmtGetHostNameIdByName.Query(value)
+	default:
+		panic("func dbGetKeyByValue: Invalid key/value type: " + valueType)
+	}
+
+	if err != nil {
+		panic("func dbGetKeyByValue: " + err.Error())
+	}
+
+	var id string
+	if row.Next() {
+		row.Scan(&id)
+	}
+
+	if row.Next() { // We have a problem if more than one lines match the fingerprint
+		// for now gracefully ignore - otherwise it should bounce it here
+		ifPrintln(-1, fmt.Sprintf("dbGetKeyByValue: MORE THAN ONE FINGERPRINTES RETURNED for %s.", value))
+	}
+	return id
+}
+
+/*
+// Performance optimized to get a Fingerprint ID by the Fingerprint
+func (db *DB) GetNodeIdByFingerprint(fp string) string {
+	row, err := db.stmtGetNodeIdByFp.Query(fp)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var id string
+	if row.Next() {
+		row.Scan(&id)
+	}
+
+	if row.Next() { // We have a problem if more than one lines match the fingerprint
+		ifPrintln(3, fmt.Sprintf("MORE THAN ONE FINGERPRINTES RETURNED for %s.", fp))
+		//gracefully ignore - otherwise it should bounce it here
+	}
+	return id
+}*/
+
+// Logs the snapshot and returns its TorQueries ID
+func (db *DB) AddToTorQueries(version string, relays_published string, bridges_published string, acquisition_ts string) string {
+	ifPrintln(4, "func AddToTorQueries("+version+", "+relays_published+","+bridges_published+","+acquisition_ts+")")
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	res, err := db.stmtTorQueries.Exec(version, relays_published, bridges_published, acquisition_ts)
+	if err != nil {
+		fmt.Println("SQL Query broke:")
+		fmt.Println(db.stmtTorQueries)
+		fmt.Printf("%s, %s, %s, %s\n", version, relays_published, bridges_published, acquisition_ts)
+		panic("func AddToTorQueries: " + err.Error())
+	}
+	lastID_int64, err := res.LastInsertId()
+	if err != nil {
+		panic("func AddToTorQueries: " + err.Error())
+	}
+	return fmt.Sprintf("%d", lastID_int64)
+}
+
+func ipPort(input string) (string, string) {
+	//ifPrintln(8, "func ipPort("+input+")")
+	var ip, port string
+	if input[0] == '[' { // IPv6
+		ip6AndPort := strings.SplitN(input, "]", 2)
+		checkIP := net.ParseIP(ip6AndPort[0][1:])
+		if checkIP == nil {
+			log.Fatal("ipPort: problem parsing IPv6: " + ip)
+		}
+		ip = checkIP.String()
+
+		if !strings.Contains(ip, "::") && strings.Contains(ip, ":0:") { // Normalized already
+			re := regexp.MustCompile(`:0:`)
+			matches := re.FindAllStringSubmatchIndex(ip, 1)
+			if len(matches) > 0 {
+				last := matches[0]
+				ip = ip[:last[0]+1] + ip[last[1]-1:]
+			}
+		}
+
+		if len(ip6AndPort) >= 2 {
+			port = ip6AndPort[1][1:]
+		}
+	} else {
+		ip4AndPort := strings.SplitN(input, ":", 2)
+		ip = ip4AndPort[0]
+		if len(ip4AndPort) >= 2 {
+			port = ip4AndPort[1]
+		}
+	}
+	return ip, port
+}
+
+func (db *DB) AddToIP(table string, fpid string, tsIns string, tsRls string, ipAndPort string) {
+	ifPrintln(6, "func AddToIP(type="+table+"): "+ipAndPort)
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	defer ifPrintln(6, "AddToIP: RETURN")
+
+	if len(ipAndPort) == 0 {
+		ifPrintln(6, "Empty IP/port")
+		return
+	}
+
+	// Check if it is IPv4 or IPv6
+	var stmt *sql.Stmt
+	if ipAndPort[0] == '[' { // IPv6
+		switch table {
+		case "Or":
+			stmt = db.stmtAddOrV6
+			break
+		case "Ex":
+			stmt = db.stmtAddExitV6
+			break
+		case "Di":
+			stmt = db.stmtAddDirV6
+			break
+		case "Un":
+			stmt = db.stmtAddUnV6
+			break
+		default:
+			log.Fatal("Reached unexpected case (" + table + ") in IPv6 switch for (" + ipAndPort + ") in AddToIP().")
+		}
+	} else {
+		switch table {
+		case "Or":
+			stmt = db.stmtAddOrV4
+			break
+		case "Ex":
+			stmt = db.stmtAddExitV4
+			break
+		case "Di":
+			stmt = db.stmtAddDirV4
+			break
+		case "Un":
+			stmt = db.stmtAddUnV4
+			break
+		default:
+			log.Fatal("Reached unexpected case (" + table + ") in IPv4 switch for (" + ipAndPort + ") in AddToIP().")
+		}
+	}
+	var err error
+	if table == "Ex" {
+		ip := ipAndPort
+		_, err = stmt.Exec(fpid, tsIns, tsRls, ip)
+	} else {
+		ip, port := ipPort(ipAndPort)
+		_, err = stmt.Exec(fpid, tsIns, tsRls, ip, port)
+	}
+	if err != nil {
+		panic("func AddToIP(" + table + "): " + err.Error())
+	}
+}
+
+func (db *DB) UpdateIfNeededRelayAddressRLS(table string, fpid string, tsRls string, or string) {
+	ifPrintln(4, fmt.Sprintf("func UpdateIfNeededRelayAddressRLS: %s, %s, %s, %s", table, fpid, tsRls, or))
+	defer ifPrintln(4, "func UpdateIfNeededRelayAddressRLS: RETURN")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	ip, port := ipPort(or)
+	var rec (map[string]string)
+	var updStmt *sql.Stmt
+	if or[0] == '[' { // IPv6
+		switch table {
+		case "Or":
+			rec = db.latestOr6[fpid][ip]
+			updStmt = db.stmtUpdOr6RLS
+			break
+		case "Ex":
+			rec = db.latestEx6[fpid][ip]
+			updStmt = db.stmtUpdEx6RLS
+			break
+		case "Di":
+			rec = db.latestDi6[fpid][ip]
+			updStmt = db.stmtUpdDi6RLS
+			break
+		case "Un":
+			rec = db.latestUn6[fpid][ip]
+			updStmt = db.stmtUpdUn6RLS
+			break
+		default:
+			panic("UpdateIfNeededRelayAddressRLS: V6 swtch/default: ")
+		}
+	} else {
+		switch table {
+		case "Or":
+			rec = db.latestOr4[fpid][ip]
+			updStmt = db.stmtUpdOr4RLS
+			break
+		case "Ex":
+			rec = db.latestEx4[fpid][ip]
+			updStmt = db.stmtUpdEx4RLS
+			break
+		case "Di":
+			rec = db.latestDi4[fpid][ip]
+			updStmt = db.stmtUpdDi4RLS
+			break
+		case "Un":
+			rec = db.latestUn4[fpid][ip]
+			updStmt = db.stmtUpdUn4RLS
+			break
+		default:
+			panic("UpdateIfNeededRelayAddressRLS: V4 swtch/default: ")
+		}
+	}
+
+	if rec["port"] == port {
+		if rec["RecordLastSeen"] == tsRls {
+			ifPrintln(5, "func : COMPLETE MATCH: no need to update RLS for: "+tsRls+"; "+rec["RecordLastSeen"]+"; ")
+		} else {
+			ifPrintln(5, fmt.Sprintf("func UpdateIfNeededRelayAddressRLS: Updating RLS in %s. Rec id: %s. New time: %s", table, rec["ID"], tsRls))
+			_, err := updStmt.Exec(tsRls, rec["ID"])
+			if err != nil {
+				panic("func UpdateIfNeededRelayAddressRLS: " + err.Error())
+			}
+		}
+	} else {
+		ifPrintln(5, fmt.Sprintf("func UpdateIfNeededRelayAddressRLS: %s new IP for %s: Inserting %s in DB and cache", fpid, table, or))
+
+		// Adds IP to the corresponding Or, Exor Di table specified in table
+		db.AddToIP(table, fpid, tsRls, tsRls, or)
+	}
+}
+
+// Inserts a TorRelays record. The lookup values are IDs as returned by Value2ID,
+// asNumber as returned by NormalizeASNumber and the version status as returned by
+// VersionStatusValues. Returns the ID of the new record.
+func (db *DB) AddTorRelay(fpid, countryid, regionid, cityid string, asNumber interface{}, platformid, versionid string,
+	versionStatus interface{}, recommended interface{}, contactid, exitp, exitps, exitps6 string,
+	nick string, lastChanged string, firstSeen string, tsIns string, tsRls string,
+	latitude interface{}, longitude interface{}, jsFlags []byte, jsRelay []byte) string {
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	res, err := db.stmtAddTorRelays.Exec(fpid, countryid, regionid, cityid, asNumber, platformid, versionid, versionStatus, recommended, contactid,
+		exitp, exitps, exitps6, nick, lastChanged, firstSeen, tsIns, tsRls, latitude, longitude, jsFlags, jsRelay)
+	if err != nil {
+		panic("func AddTorRelay: stmtAddTorRelays.Exec: " + err.Error())
+	}
+	lastID_int64, err := res.LastInsertId()
+	if err != nil {
+		panic("func AddTorRelay: " + err.Error())
+	}
+	return fmt.Sprintf("%d", lastID_int64)
+}
+
+func (db *DB) UpdateTorRelayRLS(id string, newTS string) {
+	ifPrintln(4, "UpdateTorRelayRLS: id: "+id+"; new timestamp: "+newTS)
+	defer ifPrintln(4, "UpdateTorRelayRLS: success")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	_, err := db.stmtUpdTorRelaysRLS.Exec(newTS, id)
+	if err != nil {
+		panic("func UpdateTorRelayRLS: " + err.Error())
+	}
+}
+
+// Host names follow the same inserted/last seen logic as the relay addresses.
+// If the latest record for the fingerprint already has the host name (with the
+// same verification status) only its RLS is moved, otherwise a new interval is opened.
+func (db *DB) UpdateIfNeededRelayHostNameRLS(fpid string, tsRls string, hostName string, verified bool) {
+	ifPrintln(4, fmt.Sprintf("func UpdateIfNeededRelayHostNameRLS: %s, %s, %s, %t", fpid, tsRls, hostName, verified))
+	defer ifPrintln(4, "func UpdateIfNeededRelayHostNameRLS: RETURN")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	verifiedStr := "0"
+	if verified {
+		verifiedStr = "1"
+	}
+
+	// Only the host names of the latest snapshot of the relay are extended; the cache
+	// also keeps the ones it stopped using since it was initialized
+	rec, ok := db.latestHn[fpid][hostName]
+	if ok && rec["Verified"] == verifiedStr && (rec["RecordLastSeen"] == tsRls || rec["RecordLastSeen"] == latestRLSBefore(db.latestHn[fpid], tsRls)) {
+		if rec["RecordLastSeen"] == tsRls {
+			ifPrintln(5, "func UpdateIfNeededRelayHostNameRLS: COMPLETE MATCH: no need to update RLS for: "+tsRls)
+		} else {
+			ifPrintln(5, fmt.Sprintf("func UpdateIfNeededRelayHostNameRLS: Updating RLS. Rec id: %s. New time: %s", rec["ID"], tsRls))
+			_, err := db.stmtUpdRelayHostNameRLS.Exec(tsRls, rec["ID"])
+			if err != nil {
+				panic("func UpdateIfNeededRelayHostNameRLS: " + err.Error())
+			}
+			rec["RecordLastSeen"] = tsRls
+		}
+	} else {
+		ifPrintln(5, fmt.Sprintf("func UpdateIfNeededRelayHostNameRLS: %s new host name: Inserting %s in DB", fpid, hostName))
+		hnid := db.Value2ID("hostname", hostName)
+		res, err := db.stmtAddRelayHostName.Exec(fpid, hnid, verified, tsRls, tsRls)
+		if err != nil {
+			panic("func UpdateIfNeededRelayHostNameRLS: " + err.Error())
+		}
+		lastID, err := res.LastInsertId()
+		if err != nil {
+			panic("func UpdateIfNeededRelayHostNameRLS: " + err.Error())
+		}
+
+		// The next snapshots extend the new record until the caches are reinitialized
+		if db.latestHn[fpid] == nil {
+			db.latestHn[fpid] = make(map[string](map[string]string))
+		}
+		db.latestHn[fpid][hostName] = map[string]string{"ID": fmt.Sprintf("%d", lastID), "RecordLastSeen": tsRls, "Verified": verifiedStr}
+	}
+}
+
+// Latest RecordLastSeen of the cached records before ts
+func latestRLSBefore(recs map[string](map[string]string), ts string) string {
+	latest := ""
+	for _, rec := range recs {
+		if rls := rec["RecordLastSeen"]; rls < ts && rls > latest {
+			latest = rls
+		}
+	}
+	return latest
+}
+
+//***************************************************************************
+// Presence intervals
+
+// Returns the acquisition timestamps (DLTS format) of the snapshots logged in
+// TorQueries right before and right after dlts. Empty if there is none.
+func (db *DB) getSnapshotNeighbours(dlts string) (string, string) {
+	ifPrintln(4, "func getSnapshotNeighbours: "+dlts)
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	var prev, next string
+	err := db.dbh.QueryRow("SELECT IFNULL(DATE_FORMAT( max(AcquisitionTimestamp), '%Y%m%d%H%i%s'), '') FROM TorQueries WHERE AcquisitionTimestamp < ?;", dlts).Scan(&prev)
+	if err != nil {
+		panic("func getSnapshotNeighbours: " + err.Error())
+	}
+	err = db.dbh.QueryRow("SELECT IFNULL(DATE_FORMAT( min(AcquisitionTimestamp), '%Y%m%d%H%i%s'), '') FROM TorQueries WHERE AcquisitionTimestamp > ?;", dlts).Scan(&next)
+	if err != nil {
+		panic("func getSnapshotNeighbours: " + err.Error())
+	}
+	ifPrintln(4, "func getSnapshotNeighbours: RETURN: "+prev+", "+next)
+	return prev, next
+}
+
+// Records which relays (fingerprint IDs) were present in the snapshot taken at dlts.
+// An interval is extended only if the relay was present in the directly preceding
+// snapshot, so a relay missing from a snapshot gets its interval closed.
+// Snapshots may be imported out of order: an import between two existing snapshots
+// extends or merges the neighbouring intervals, or splits the interval of a relay
+// which turns out to be absent.
+func (db *DB) UpdatePresenceIntervals(present map[string]bool, dlts string) {
+	ifPrintln(3, fmt.Sprintf("func UpdatePresenceIntervals: %d relays at %s", len(present), dlts))
+	defer ifPrintln(3, "func UpdatePresenceIntervals: RETURN")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	prev, next := db.getSnapshotNeighbours(dlts)
+	lo, hi := dlts, dlts
+	if prev != "" {
+		lo = prev
+	}
+	if next != "" {
+		hi = next
+	}
+
+	// All the intervals which may be affected by this snapshot
+	intervals := db.SQLQueryTYPEOfMaps("mapOfMapOfMaps", "SELECT ID_NodeFingerprints, ID, DATE_FORMAT( IntervalStart, '%Y%m%d%H%i%s') as IntervalStart, "+
+		"DATE_FORMAT( IntervalEnd, '%Y%m%d%H%i%s') as IntervalEnd FROM PresenceIntervals WHERE IntervalEnd >= ? AND IntervalStart <= ?;",
+		lo, hi).(map[string](map[string](map[string]string)))
+
+	var err error
+	for _, c := range planPresenceChanges(present, intervals, dlts, prev, next) {
+		ifPrintln(5, fmt.Sprintf("UpdatePresenceIntervals: %+v", c))
+		switch c.op {
+		case "add":
+			_, err = db.stmtAddPresence.Exec(c.fpid, c.start, c.end)
+		case "start":
+			_, err = db.stmtUpdPresenceStart.Exec(c.start, c.id)
+		case "end":
+			_, err = db.stmtUpdPresenceEnd.Exec(c.end, c.id)
+		case "delete":
+			_, err = db.stmtDelPresence.Exec(c.id)
+		}
+		if err != nil {
+			panic("func UpdatePresenceIntervals: " + err.Error())
+		}
+	}
+}
+
+// A change to PresenceIntervals: "add" an interval of fpid, move the "start" or the
+// "end" of interval id, or "delete" it
+type presenceChange struct {
+	op    string
+	fpid  string
+	id    string
+	start string
+	end   string
+}
+
+// The changes recording the snapshot at dlts, given the intervals overlapping
+// [prev, next] by fingerprint ID and interval ID, and the neighbouring snapshots
+func planPresenceChanges(present map[string]bool, intervals map[string](map[string](map[string]string)), dlts string, prev string, next string) []presenceChange {
+	var changes []presenceChange
+
+	// Absent relays: split an interval which spans over this snapshot
+	absent := make([]string, 0, len(intervals))
+	for fpid := range intervals {
+		if !present[fpid] {
+			absent = append(absent, fpid)
+		}
+	}
+	sort.Strings(absent)
+	for _, fpid := range absent {
+		ids := make([]string, 0, len(intervals[fpid]))
+		for id := range intervals[fpid] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			rec := intervals[fpid][id]
+			if rec["IntervalStart"] < dlts && rec["IntervalEnd"] > dlts {
+				changes = append(changes, presenceChange{op: "end", fpid: fpid, id: id, end: prev},
+					presenceChange{op: "add", fpid: fpid, start: next, end: rec["IntervalEnd"]})
+			}
+		}
+	}
+
+	fpids := make([]string, 0, len(present))
+	for fpid, ok := range present {
+		if ok {
+			fpids = append(fpids, fpid)
+		}
+	}
+	sort.Strings(fpids)
+
+PresentLoop:
+	for _, fpid := range fpids {
+		var before, after map[string]string
+		for _, rec := range intervals[fpid] {
+			if rec["IntervalStart"] <= dlts && dlts <= rec["IntervalEnd"] { // Snapshot already accounted for
+				continue PresentLoop
+			}
+			if prev != "" && rec["IntervalEnd"] == prev {
+				before = rec
+			}
+			if next != "" && rec["IntervalStart"] == next {
+				after = rec
+			}
+		}
+
+		switch {
+		case before != nil && after != nil: // Fills the gap between two intervals
+			changes = append(changes, presenceChange{op: "end", fpid: fpid, id: before["ID"], end: after["IntervalEnd"]},
+				presenceChange{op: "delete", fpid: fpid, id: after["ID"]})
+		case before != nil:
+			changes = append(changes, presenceChange{op: "end", fpid: fpid, id: before["ID"], end: dlts})
+		case after != nil:
+			changes = append(changes, presenceChange{op: "start", fpid: fpid, id: after["ID"], start: dlts})
+		default:
+			changes = append(changes, presenceChange{op: "add", fpid: fpid, start: dlts, end: dlts})
+		}
+	}
+	return changes
+}
+
+//***************************************************************************
+// Add key/value variations
+
+func (db *DB) addKeyValue_CC(cc string, country_name string) string {
+	ifPrintln(4, "func addKeyValue_CC("+cc+", "+country_name+"): ")
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	if len(cc) == 0 || len(country_name) == 0 {
+		return "" // Prevent this from causing a DB error
+	}
+	return db.addKeyValue_real("country", cc, country_name)
+}
+
+func (db *DB) addKeyValue(valueType string, value string) string {
+	ifPrintln(4, "func addKeyValue("+valueType+", "+value+"): ")
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	return db.addKeyValue_real(valueType, value, "")
+}
+
+func (db *DB) addKeyValue_real(valueType string, value string, id string) string {
+	ifPrintln(4, "func addKeyValue_real("+valueType+", "+value+", "+id+"): ")
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	var lastID string
+	var err error
+	var res sql.Result
+	var cache *map[string]string
+
+	// Note that the caches are updated prior to the actual DB transaction and return code
+	// verification. That is OK because in case of a DB transaction failure the program would exit
+	switch valueType {
+	case "fingerprint":
+		res, err = db.stmtAddNodeFingerprints.Exec(value)
+		cache = &db.fp2idMap
+	case "country":
+		res, err = db.stmtAddCountryCode.Exec(value, id) // value is CC, id is Country_name
+		cache = &db.cc2cyNameMap
+	case "region":
+		res, err = db.stmtAddRegion.Exec(value)
+		cache = &db.region2idMap
+	case "city":
+		res, err = db.stmtAddCity.Exec(value)
+		cache = &db.city2idMap
+	case "platform":
+		res, err = db.stmtAddPlatform.Exec(value)
+		cache = &db.platform2idMap
+	case "version":
+		res, err = db.stmtAddVersion.Exec(value)
+		cache = &db.version2idMap
+	case "contact":
+		res, err = db.stmtAddContact.Exec(value)
+		cache = &db.contact2idMap
+	case "exitp":
+		res, err = db.stmtAddExitPolicy.Exec(value)
+		cache = &db.exitPol2idMap
+	case "exitps":
+		res, err = db.stmtAddExitPolicySummary.Exec(value)
+		cache = &db.exitPolSum2idMap
+	case "exitps6":
+		res, err = db.stmtAddExitPolicyV6Summary.Exec(value)
+		cache = &db.exitPolV6Sum2idMap
+	case "hostname":
+		res, err = db.stmtAddHostName.Exec(value, reverseHostName(value))
+		cache = &db.hostName2idMap
+	default:
+		panic("func addKeyValue_real: Invalid key/value type: " + valueType)
+	}
+
+	if err != nil {
+		errDetail, _ := err.(*mysql.MySQLError)
+		ifPrintln(4, "Add to "+valueType+": SQL insert error")
+		switch errDetail.Number {
+		case 1062: // Error 1062 means duplicate fingerprint (normal after second run)
+			ifPrintln(6, "DETECTED A DUPLICATE (MySQL code 1062)")
+			break
+		case 1406: // Error 1406: Data too long for column 'xxx' at row 1
+			//ifPrintln(-2, "DB field truncated (MySQL code 1406)")
+			panic("func addKeyValue_real: (" + valueType + ") " + value + ": => DB field truncated (MySQL code 1406)\n" + err.Error())
+		default:
+			panic("func addKeyValue_real: (" + valueType + ") " + value + ":\n" + err.Error())
+		}
+
+		// Note before this function is called fp2id would have checked the cache
+		lastID = db.dbGetKeyByValue(valueType, value)
+		ifPrintln(4, fmt.Sprintf("LastID (duplicate): %s", lastID))
+	} else {
+		if valueType != "country" {
+			lastID_int64, _ := res.LastInsertId()
+			lastID = fmt.Sprintf("%d", lastID_int64)
+		} else {
+			lastID = value
+		}
+		(*cache)[value] = lastID
+		ifPrintln(4, fmt.Sprintf("LastID (new insert): %s", lastID))
+		if valueType == "contact" {
+			db.addContactDetails(lastID, value)
+		}
+		if err != nil {
+			panic("func addKeyValue_real: " + err.Error())
+		}
+	}
+	ifPrintln(4, "func addKeyValue_real: RETURN: "+lastID)
+	return lastID
+}
+
+//***************************************************************************
+// ID lookup functions
+
+func (db *DB) cc2countryName(cc string) string {
+	ifPrintln(4, "func cc2countryName("+cc+")")
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	// If fingerprint is in the cache already, return it.
+	if value, ok := db.cc2cyNameMap[cc]; ok {
+		ifPrintln(4, fmt.Sprintf("Cache hit for cc %s, returning %s.", cc, value))
+		return value
+	} else {
+		return ""
+	}
+}
+
+// Returns the ID of a fingerprint already in the DB, or "" without adding it
+func (db *DB) KnownFingerprintID(fingerprint string) string {
+	return db.fp2idMap[fingerprint]
+}
+
+// If the value is in the corresponding cache, return it.
+// If not in the cache, update the cache, enter in the DB and return the DB id
+func (db *DB) Value2ID(valueType string, value string) string {
+	ifPrintln(4, "func Value2ID("+valueType+", "+value+")")
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+	var ok bool
+	var id string
+	var cache *map[string]string
+
+	switch valueType {
+	case "fingerprint":
+		cache = &db.fp2idMap
+		break
+	case "country": // this is a VERY SPECIAL case
+		// For countries we do not need to do a cache resolution as the
+		// ##### value = strings.ToLower(value) // Ensure there are not small letter coming from the Consensus
+		cache = &db.cc2cyNameMap
+		break
+	case "region":
+		cache = &db.region2idMap
+		break
+	case "city":
+		cache = &db.city2idMap
+		break
+	case "platform":
+		cache = &db.platform2idMap
+		break
+	case "version":
+		cache = &db.version2idMap
+		break
+	case "contact":
+		cache = &db.contact2idMap
+		break
+	case "exitp":
+		cache = &db.exitPol2idMap
+		break
+	case "exitps":
+		cache = &db.exitPolSum2idMap
+		break
+	case "exitps6":
+		cache = &db.exitPolV6Sum2idMap
+		break
+	case "hostname":
+		cache = &db.hostName2idMap
+		break
+	default:
+		panic("Value2ID: Invalid key/value type: " + valueType)
+	}
+
+	if id, ok = (*cache)[value]; ok {
+		ifPrintln(6, fmt.Sprintf("Value2ID: Cache hit for %s %s, returning %s.", valueType, value, id))
+	} else {
+		if valueType != "country" {
+			id = db.addKeyValue(valueType, value)
+			ifPrintln(4, fmt.Sprintf("Value2ID: Cache miss for %s %s, added to DB, returning %s.", valueType, value, id))
+		} else {
+			id = ""
+			ifPrintln(4, fmt.Sprintf("Value2ID: Cache miss on country ID %s, returning %s.", value, id))
+		}
+	}
+	ifPrintln(4, "func Value2ID: RETURN id: "+id+"\n")
+	return id
+}
+
+//***************************************************************************
+// Utility functions
+
+func (db *DB) NormalizeCountryID(cid string, cname string) string {
+	// This is a VERY SPECIAL case
+	// We do not need to lookup the country code as we already have it from
+	// the Consensus and we just need to ensure it's lower case
+	// However, we also need to ensure the country code exists in the Countries
+	// table, and if not add it
+	countryid := ""
+	if len(cid) > 0 { // Country code is not empty
+		countryid = strings.ToLower(cid)
+		if len(db.Value2ID("country", countryid)) == 0 { // No country code match in DB, add it
+			db.addKeyValue_CC(cid, cname) // This will also update the cache
+		}
+	} // else countryid will be ""
+	return countryid
+}
+
+// Escapes the LIKE wildcards so user supplied values can be used as literal prefixes
+func (db *DB) escapeLikeWildcards(str string) string {
+	// Keep "\" as the first in the escape sequence
+	escape_chars := []string{"\\", "%", "_"}
+	for _, c := range escape_chars {
+		str = strings.Replace(str, c, "\\"+c, -1)
+	}
+	return str
+}
+
+func (db *DB) escapePercentSign(str string) string {
+	// Backslash "escape" single quote, double quote, backslash, NULL
+	// Keep "\" as the first in the escape sequence
+	escape_chars := []string{"%"}
+	for _, c := range escape_chars {
+		str = strings.Replace(str, c, "%"+c, -1)
+	}
+	return str
+}
+
+// Reverses the labels of a host name: "relay.example.com" => "com.example.relay"
+// Stored next to the host name so domain suffix searches become index prefix scans.
+func reverseHostName(hostName string) string {
+	labels := strings.Split(hostName, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
+
+// Great-circle distance in kilometers between two points (haversine formula)
+func haversineKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Geo coordinates as stored in the DB: NULL when the GeoIP lookup failed (Onionoo omits both)
+func GeoValues(lat float64, lon float64) (interface{}, interface{}) {
+	if lat == 0 && lon == 0 {
+		return nil, nil
+	}
+	return lat, lon
+}
+
+// Lower case and strip the trailing root dot, as PTR records are returned fully qualified
+func NormalizeHostName(hostName string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostName)), ".")
+}
+
+// TOR Query plugin functions
+// Placeholders per variable length IN list; larger lists are queried in chunks
+const inListChunkSize = 1000
+
+// Returns the TorRelays records with the IDs, keyed by ID. IDs which are not numeric are ignored.
+func (db *DB) GetTorRelaysByIDs(ids []string) map[string](map[string]string) {
+	ifPrintln(3, fmt.Sprintf("func GetTorRelaysByIDs: %d IDs", len(ids)))
+	defer ifPrintln(3, "GetTorRelaysByIDs: END")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database GetTorRelaysByIDs.")
+	}
+
+	var args []interface{}
+	for _, id := range ids {
+		if _, err := strconv.ParseUint(id, 10, 32); err == nil {
+			args = append(args, id)
+		}
+	}
+
+	lrd := make(map[string](map[string]string))
+	for len(args) > 0 {
+		n := len(args)
+		if n > inListChunkSize {
+			n = inListChunkSize
+		}
+		for id, relay := range db.getTorRelaysByIDs(args[:n]) {
+			lrd[id] = relay
+		}
+		args = args[n:]
+	}
+	return lrd
+}
+
+func (db *DB) getTorRelaysByIDs(args []interface{}) map[string](map[string]string) {
+	return db.SQLQueryTYPEOfMaps("mapOfMaps",
+		`SELECT tr.ID ID, Fingerprint, Nickname, DATE_FORMAT( First_seen, "%Y-%m-%d") as First_seen, 
+		DATE_FORMAT( RecordTimeInserted, "%Y-%m-%d") as RecordTimeInserted, 
+		DATE_FORMAT( RecordLastSeen, "%Y-%m-%d") as RecordLastSeen, 
+		ID_Countries Country, RegionName, CityName, PlatformName, VersionName, ContactName, 
+		DATE_FORMAT( Last_changed_address_or_port, "%Y-%m-%d") as Last_changed_address_or_port, 
+		ExitPolicy, ExitPolicySummary, ExitPolicyV6Summary, jsd
+		FROM TorRelays tr
+		LEFT JOIN NodeFingerprints nf ON tr.ID_NodeFingerprints = nf.ID 
+		LEFT JOIN Regions r ON tr.ID_Regions = r.ID
+		LEFT JOIN Cities c ON ID_Cities = c.ID
+		LEFT JOIN Platforms p ON ID_Platforms = p.ID
+		LEFT JOIN Versions v ON ID_Versions = v.ID
+		LEFT JOIN Contacts ct ON ID_Contacts = ct.ID
+		LEFT JOIN ExitPolicies ep ON ID_ExitPolicies = ep.ID
+		LEFT JOIN ExitPolicySummaries eps ON ID_ExitPolicySummaries = eps.ID
+		LEFT JOIN ExitPolicyV6Summaries eps6 ON ID_ExitPolicyV6Summaries = eps6.ID 
+		WHERE tr.ID IN (?`+strings.Repeat(", ?", len(args)-1)+`);`, args...).(map[string](map[string]string))
+}
+
+// Returns the latest TorRelay record, within the [from, to] time range, of every relay
+// located in the country in that range
+func (db *DB) GetLatestTRsIDsByCountryCode(cc string, from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByCountryCode: "+cc+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByCountryCode: END")
+
+	result := make(map[string]string)
+	matched, err := regexp.MatchString(`^[a-z][a-z]$`, cc)
+	if !matched || err != nil {
+		return result
+	}
+	query := `SELECT ID, ID_NodeFingerprints FROM TorRelays WHERE (ID_NodeFingerprints, RecordLastSeen) IN 
+		(SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM TorRelays WHERE ID_Countries = ? AND RecordLastSeen >= ? AND RecordTimeInserted <= ? GROUP BY ID_NodeFingerprints)`
+	result = db.SQLQueryKeyValue(query, cc, from, to)
+	return result
+}
+
+// Looks up the e-mail address in the parsed contact details (including abuse addresses).
+// Falls back to a substring match over the raw contact strings if there is no exact match.
+func (db *DB) GetLatestTRsIDsByEmail(email string, from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByEmail: "+email+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByEmail: END")
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	result := db.getLatestTRsIDsByContactDetail([]string{"email", "abuse"}, email, from, to)
+	if len(result) > 0 {
+		return result
+	}
+
+	// The full-text index finds the contacts with the whole address; only partial
+	// addresses need the full scan
+	like := "%" + db.escapeLikeWildcards(email) + "%"
+	if _, ftQuery := ContactSearchQuery(email); ftQuery != "" {
+		query := `SELECT max(tr.ID) as ID, tr.ID_NodeFingerprints as ID_NodeFingerprints FROM Contacts c JOIN TorRelays tr ON c.ID = tr.ID_Contacts 
+			WHERE MATCH(ContactName) AGAINST(? IN BOOLEAN MODE) AND ContactName LIKE ? 
+			AND tr.RecordLastSeen >= ? AND tr.RecordTimeInserted <= ? GROUP BY tr.ID_NodeFingerprints;`
+		if result = db.SQLQueryKeyValue(query, ftQuery, like, from, to); len(result) > 0 {
+			return result
+		}
+	}
+	query := `SELECT max(tr.ID) as ID, tr.ID_NodeFingerprints as ID_NodeFingerprints FROM Contacts c JOIN TorRelays tr ON c.ID = tr.ID_Contacts 
+		WHERE ContactName LIKE ? AND tr.RecordLastSeen >= ? AND tr.RecordTimeInserted <= ? GROUP BY tr.ID_NodeFingerprints;`
+	result = db.SQLQueryKeyValue(query, like, from, to)
+	return result
+}
+
+// Relays whose contact e-mail addresses or URLs are in the domain
+func (db *DB) GetLatestTRsIDsByContactDomain(domain string, from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByContactDomain: "+domain+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByContactDomain: END")
+
+	return db.getLatestTRsIDsByContactDetail([]string{"domain"}, strings.TrimPrefix(NormalizeHostName(domain), "www."), from, to)
+}
+
+// Relays whose contact lists the PGP key. Accepts the full fingerprint or a long/short key ID.
+func (db *DB) GetLatestTRsIDsByPGP(key string, from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByPGP: "+key+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByPGP: END")
+
+	if fp := normalizePGPFingerprint(key); fp != "" {
+		return db.getLatestTRsIDsByContactDetail([]string{"pgp"}, fp, from, to)
+	}
+
+	keyID := strings.TrimPrefix(strings.ToUpper(strings.Replace(strings.TrimSpace(key), " ", "", -1)), "0X")
+	if (len(keyID) != 8 && len(keyID) != 16) || strings.Trim(keyID, "0123456789ABCDEF") != "" {
+		return make(map[string]string)
+	}
+	query := `SELECT max(tr.ID) as ID, tr.ID_NodeFingerprints as ID_NodeFingerprints FROM ContactDetails cd JOIN TorRelays tr ON cd.ID_Contacts = tr.ID_Contacts 
+		WHERE cd.FieldType = 'pgp' AND cd.FieldValue LIKE ? AND tr.RecordLastSeen >= ? AND tr.RecordTimeInserted <= ? GROUP BY tr.ID_NodeFingerprints;`
+	return db.SQLQueryKeyValue(query, "%"+keyID, from, to)
+}
+
+// Exact lookup over the (FieldType, FieldValue) index of the parsed contact details
+func (db *DB) getLatestTRsIDsByContactDetail(fieldTypes []string, value string, from string, to string) map[string]string {
+	args := []interface{}{}
+	for _, t := range fieldTypes {
+		args = append(args, t)
+	}
+	args = append(args, value, from, to)
+
+	query := `SELECT max(tr.ID) as ID, tr.ID_NodeFingerprints as ID_NodeFingerprints FROM ContactDetails cd JOIN TorRelays tr ON cd.ID_Contacts = tr.ID_Contacts 
+		WHERE cd.FieldType IN (?` + strings.Repeat(", ?", len(fieldTypes)-1) + `) AND cd.FieldValue = ? 
+		AND tr.RecordLastSeen >= ? AND tr.RecordTimeInserted <= ? GROUP BY tr.ID_NodeFingerprints;`
+	return db.SQLQueryKeyValue(query, args...)
+}
+
+// Returns the latest TorRelay record, within the [from, to] time range, of every relay
+// which used the IP (v4 or v6) in any role in that range
+func (db *DB) GetLatestTRsIDsByIP(ip string, from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByIP: "+ip+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByIP: END")
+	result := make(map[string]string) // return value
+
+	tables, ip := addressTablesForIP(ip)
+	if len(tables) == 0 {
+		return result
+	}
+
+	var union []string
+	var args []interface{}
+	for _, t := range tables {
+		union = append(union, fmt.Sprintf("SELECT ID_NodeFingerprints FROM %s WHERE %s = %s(?) AND RecordLastSeen >= ? AND RecordTimeInserted <= ?", t.table, t.ipColumn, t.aton))
+		args = append(args, ip, from, to)
+	}
+	args = append(args, from, to)
+	query := `SELECT ID, ID_NodeFingerprints FROM TorRelays WHERE (ID_NodeFingerprints, RecordLastSeen) IN 
+		(SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM TorRelays WHERE ID_NodeFingerprints IN 
+		(` + strings.Join(union, " UNION ") + `) AND RecordLastSeen >= ? AND RecordTimeInserted <= ? GROUP BY ID_NodeFingerprints);`
+	result = db.SQLQueryKeyValue(query, args...)
+	return result
+}
+
+// Address tables holding addresses of the family of ip (v4 or v6) and ip in canonical form.
+// No tables are returned if ip is not a valid address.
+func addressTablesForIP(ip string) ([]historyAddressTable, string) {
+	checkIP := net.ParseIP(strings.TrimSpace(ip))
+	if checkIP == nil {
+		return nil, ""
+	}
+	column := "ip6"
+	if checkIP.To4() != nil {
+		column = "ip4"
+	}
+	var tables []historyAddressTable
+	for _, t := range historyAddressTables {
+		if t.ipColumn == column {
+			tables = append(tables, t)
+		}
+	}
+	return tables, checkIP.String()
+}
+
+// Returns every address interval of ip (v4 or v6) overlapping the [from, to] time range,
+// across the OR, exit, directory and unreachable OR address tables. A non empty port
+// restricts the roles having ports; exit addresses carry no port and always match.
+// Each row holds Role, Fingerprint, ip, port, RecordTimeInserted, RecordLastSeen and
+// ID_TorRelays, the latest TorRelays record of the relay valid during the overlap.
+func (db *DB) GetAddressIntervalsByIP(ip string, port string, from string, to string) [](map[string]string) {
+	ifPrintln(3, "func GetAddressIntervalsByIP: "+ip+" "+port+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetAddressIntervalsByIP: END")
+
+	checkIP := net.ParseIP(strings.TrimSpace(ip))
+	if checkIP == nil {
+		return [](map[string]string){}
+	}
+	return db.getAddressIntervalsInRange(checkIP, checkIP, port, from, to)
+}
+
+// Same as GetAddressIntervalsByIP for all the addresses in a v4 or v6 CIDR block
+func (db *DB) GetAddressIntervalsByCIDR(cidr string, port string, from string, to string) [](map[string]string) {
+	ifPrintln(3, "func GetAddressIntervalsByCIDR: "+cidr+" "+port+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetAddressIntervalsByCIDR: END")
+
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return [](map[string]string){}
+	}
+	first := ipNet.IP
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^ipNet.Mask[i]
+	}
+	return db.getAddressIntervalsInRange(first, last, port, from, to)
+}
+
+// Same as GetAddressIntervalsByIP for all the addresses between first and last (inclusive),
+// which must be of the same family
+func (db *DB) GetAddressIntervalsByRange(first string, last string, port string, from string, to string) [](map[string]string) {
+	ifPrintln(3, "func GetAddressIntervalsByRange: "+first+" - "+last+" "+port+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetAddressIntervalsByRange: END")
+
+	firstIP := net.ParseIP(strings.TrimSpace(first))
+	lastIP := net.ParseIP(strings.TrimSpace(last))
+	if firstIP == nil || lastIP == nil || (firstIP.To4() == nil) != (lastIP.To4() == nil) {
+		return [](map[string]string){}
+	}
+	return db.getAddressIntervalsInRange(firstIP, lastIP, port, from, to)
+}
+
+// Address column value of ip as stored in the ip4 (INT UNSIGNED) or ip6 (BINARY(16)) columns
+func addressColumnValue(ip net.IP) interface{} {
+	if ip4 := ip.To4(); ip4 != nil {
+		return uint64(binary.BigEndian.Uint32(ip4))
+	}
+	return []byte(ip.To16())
+}
+
+// Range scan over the address tables of the family of first; uses the ip4/ip6 indexes
+func (db *DB) getAddressIntervalsInRange(first net.IP, last net.IP, port string, from string, to string) [](map[string]string) {
+	column := "ip6"
+	if first.To4() != nil {
+		column = "ip4"
+	}
+	lo, hi := addressColumnValue(first), addressColumnValue(last)
+
+	var union []string
+	var args []interface{}
+	for _, t := range historyAddressTables {
+		if t.ipColumn != column {
+			continue
+		}
+		portColumn := "''"
+		if t.hasPort {
+			portColumn = "CAST(port AS CHAR)"
+		}
+		query := fmt.Sprintf(`SELECT '%s' Role, Fingerprint, %s(%s) ip, %s port, 
+			DATE_FORMAT( a.RecordTimeInserted, "%%Y-%%m-%%d %%H:%%i:%%s") as RecordTimeInserted, 
+			DATE_FORMAT( a.RecordLastSeen, "%%Y-%%m-%%d %%H:%%i:%%s") as RecordLastSeen, 
+			(SELECT max(tr.ID) FROM TorRelays tr WHERE tr.ID_NodeFingerprints = a.ID_NodeFingerprints 
+				AND tr.RecordTimeInserted <= LEAST(a.RecordLastSeen, ?) AND tr.RecordLastSeen >= GREATEST(a.RecordTimeInserted, ?)) as ID_TorRelays 
+			FROM %s a LEFT JOIN NodeFingerprints nf ON a.ID_NodeFingerprints = nf.ID 
+			WHERE %s BETWEEN ? AND ? AND a.RecordLastSeen >= ? AND a.RecordTimeInserted <= ?`,
+			t.role, t.ntoa, t.ipColumn, portColumn, t.table, t.ipColumn)
+		args = append(args, to, from, lo, hi, from, to)
+		if t.hasPort && port != "" {
+			query += " AND port = ?"
+			args = append(args, port)
+		}
+		union = append(union, query)
+	}
+
+	return db.SQLQueryTYPEOfMaps("sliceOfMaps", strings.Join(union, " UNION ALL ")+" ORDER BY RecordTimeInserted, Fingerprint;", args...).([](map[string]string))
+}
+
+// Returns the latest TorRelay record, within the [from, to] time range, of every relay
+// which used the host name in that range. The name also matches as a domain suffix:
+// "example.com" returns relays seen as "example.com" as well as "relay1.example.com".
+func (db *DB) GetLatestTRsIDsByHostName(hostName string, from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByHostName: "+hostName+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByHostName: END")
+
+	result := make(map[string]string)
+	hostName = NormalizeHostName(strings.TrimPrefix(strings.TrimSpace(hostName), "*."))
+	if len(hostName) == 0 {
+		return result
+	}
+	rev := reverseHostName(hostName)
+
+	query := `SELECT ID, ID_NodeFingerprints FROM TorRelays WHERE (ID_NodeFingerprints, RecordLastSeen) IN 
+		(SELECT tr.ID_NodeFingerprints, max(tr.RecordLastSeen) FROM HostNames h 
+		LEFT JOIN Relay_host_names rh ON h.ID = rh.ID_HostNames 
+		LEFT JOIN TorRelays tr ON rh.ID_NodeFingerprints = tr.ID_NodeFingerprints 
+		WHERE (h.ReversedHostName = ? OR h.ReversedHostName LIKE ?) 
+		AND rh.RecordLastSeen >= ? AND rh.RecordTimeInserted <= ? AND tr.RecordLastSeen >= ? AND tr.RecordTimeInserted <= ? 
+		GROUP BY tr.ID_NodeFingerprints);`
+	result = db.SQLQueryKeyValue(query, rev, db.escapeLikeWildcards(rev)+".%", from, to, from, to)
+	return result
+}
+
+// Returns the presence intervals of a relay overlapping the [from, to] time range
+func (db *DB) GetPresenceIntervalsByFingerprint(fp string, from string, to string) [](map[string]string) {
+	ifPrintln(3, "func GetPresenceIntervalsByFingerprint: "+fp)
+	defer ifPrintln(3, "func GetPresenceIntervalsByFingerprint: END")
+
+	return db.SQLQueryTYPEOfMaps("sliceOfMaps", `SELECT Fingerprint, 
+		DATE_FORMAT( IntervalStart, "%Y-%m-%d %H:%i:%s") as IntervalStart, 
+		DATE_FORMAT( IntervalEnd, "%Y-%m-%d %H:%i:%s") as IntervalEnd 
+		FROM PresenceIntervals p LEFT JOIN NodeFingerprints nf ON p.ID_NodeFingerprints = nf.ID 
+		WHERE Fingerprint = ? AND IntervalEnd >= ? AND IntervalStart <= ? ORDER BY IntervalStart;`,
+		strings.ToUpper(fp), from, to).([](map[string]string))
+}
+
+// Counts the snapshots taken in the [from, to] time range and how many of them
+// contained the relay. present/total is the exact uptime of the relay in that range.
+func (db *DB) GetRelayUptime(fp string, from string, to string) (present int, total int) {
+	ifPrintln(3, "func GetRelayUptime: "+fp)
+	defer ifPrintln(3, "func GetRelayUptime: END")
+
+	err := db.dbh.QueryRow("SELECT COUNT(*) FROM TorQueries WHERE AcquisitionTimestamp BETWEEN ? AND ?;", from, to).Scan(&total)
+	if err != nil {
+		panic("func GetRelayUptime: " + err.Error())
+	}
+	err = db.dbh.QueryRow(`SELECT COUNT(DISTINCT q.ID) FROM TorQueries q 
+		JOIN PresenceIntervals p ON q.AcquisitionTimestamp BETWEEN p.IntervalStart AND p.IntervalEnd 
+		JOIN NodeFingerprints nf ON p.ID_NodeFingerprints = nf.ID 
+		WHERE nf.Fingerprint = ? AND q.AcquisitionTimestamp BETWEEN ? AND ?;`, strings.ToUpper(fp), from, to).Scan(&present)
+	if err != nil {
+		panic("func GetRelayUptime: " + err.Error())
+	}
+	return present, total
+}
+
+// Returns the TorRelay records (ID => fingerprint ID) located inside the bounding box
+// at time "at". A box with minLon > maxLon crosses the antimeridian.
+func (db *DB) GetTRsIDsInBoundingBox(minLat float64, minLon float64, maxLat float64, maxLon float64, at string) map[string]string {
+	ifPrintln(3, fmt.Sprintf("func GetTRsIDsInBoundingBox: (%f, %f) - (%f, %f) at %s", minLat, minLon, maxLat, maxLon, at))
+	defer ifPrintln(3, "func GetTRsIDsInBoundingBox: END")
+
+	lonCond := "Longitude BETWEEN ? AND ?"
+	if minLon > maxLon {
+		lonCond = "(Longitude >= ? OR Longitude <= ?)"
+	}
+	query := "SELECT ID, ID_NodeFingerprints FROM TorRelays WHERE Latitude BETWEEN ? AND ? AND " + lonCond +
+		" AND RecordTimeInserted <= ? AND RecordLastSeen >= ?;"
+	return db.SQLQueryKeyValue(query, minLat, maxLat, minLon, maxLon, at, at)
+}
+
+// Returns the TorRelay records (ID => fingerprint ID) located within radiusKm of the
+// point at time "at". The bounding box of the circle narrows the candidates using
+// the geo index, the exact distance is checked afterwards.
+func (db *DB) GetTRsIDsWithinRadius(lat float64, lon float64, radiusKm float64, at string) map[string]string {
+	ifPrintln(3, fmt.Sprintf("func GetTRsIDsWithinRadius: (%f, %f) %f km at %s", lat, lon, radiusKm, at))
+	defer ifPrintln(3, "func GetTRsIDsWithinRadius: END")
+
+	result := make(map[string]string)
+	const kmPerDegree = 111.195 // Along a meridian
+	dLat := radiusKm / kmPerDegree
+	minLat, maxLat := math.Max(-90, lat-dLat), math.Min(90, lat+dLat)
+	minLon, maxLon := -180.0, 180.0
+	if minLat > -90 && maxLat < 90 { // The circle does not contain a pole
+		dLon := dLat / math.Cos(lat*math.Pi/180)
+		if dLon < 180 {
+			minLon, maxLon = lon-dLon, lon+dLon
+			if minLon < -180 {
+				minLon += 360
+			}
+			if maxLon > 180 {
+				maxLon -= 360
+			}
+		}
+	}
+
+	lonCond := "Longitude BETWEEN ? AND ?"
+	if minLon > maxLon {
+		lonCond = "(Longitude >= ? OR Longitude <= ?)"
+	}
+	candidates := db.SQLQueryTYPEOfMaps("sliceOfMaps", "SELECT ID, ID_NodeFingerprints, Latitude, Longitude FROM TorRelays "+
+		"WHERE Latitude BETWEEN ? AND ? AND "+lonCond+" AND RecordTimeInserted <= ? AND RecordLastSeen >= ?;",
+		minLat, maxLat, minLon, maxLon, at, at).([](map[string]string))
+	for _, c := range candidates {
+		var cLat, cLon float64
+		fmt.Sscan(c["Latitude"], &cLat)
+		fmt.Sscan(c["Longitude"], &cLon)
+		if haversineKm(lat, lon, cLat, cLon) <= radiusKm {
+			result[c["ID"]] = c["ID_NodeFingerprints"]
+		}
+	}
+	return result
+}
+
+// Returns the unreachable OR addresses: addresses relays advertised in their descriptors
+// but the directory authorities could not confirm, for intervals overlapping [from, to]
+func (db *DB) GetUnreachableOrAddresses(from string, to string) [](map[string]string) {
+	ifPrintln(3, "func GetUnreachableOrAddresses: "+from+" - "+to)
+	defer ifPrintln(3, "func GetUnreachableOrAddresses: END")
+
+	return db.SQLQueryTYPEOfMaps("sliceOfMaps", `SELECT Fingerprint, INET_NTOA(ip4) ip, port, 
+		DATE_FORMAT( RecordTimeInserted, "%Y-%m-%d %H:%i:%s") as RecordTimeInserted, 
+		DATE_FORMAT( RecordLastSeen, "%Y-%m-%d %H:%i:%s") as RecordLastSeen 
+		FROM Unreachable_or_addresses_v4 u LEFT JOIN NodeFingerprints nf ON u.ID_NodeFingerprints = nf.ID 
+		WHERE RecordLastSeen >= ? AND RecordTimeInserted <= ? 
+		UNION ALL 
+		SELECT Fingerprint, INET6_NTOA(ip6) ip, port, 
+		DATE_FORMAT( RecordTimeInserted, "%Y-%m-%d %H:%i:%s") as RecordTimeInserted, 
+		DATE_FORMAT( RecordLastSeen, "%Y-%m-%d %H:%i:%s") as RecordLastSeen 
+		FROM Unreachable_or_addresses_v6 u LEFT JOIN NodeFingerprints nf ON u.ID_NodeFingerprints = nf.ID 
+		WHERE RecordLastSeen >= ? AND RecordTimeInserted <= ? 
+		ORDER BY Fingerprint, RecordTimeInserted;`, from, to, from, to).([](map[string]string))
+}
+
+// Returns the latest TorRelay record of every relay which advertised an unreachable
+// OR address in the [from, to] time range
+func (db *DB) GetLatestTRsIDsWithUnreachableAddresses(from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsWithUnreachableAddresses: "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsWithUnreachableAddresses: END")
+
+	query := `SELECT ID, ID_NodeFingerprints FROM TorRelays WHERE (ID_NodeFingerprints, RecordLastSeen) IN 
+		(SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM TorRelays WHERE ID_NodeFingerprints IN 
+		(SELECT ID_NodeFingerprints FROM Unreachable_or_addresses_v4 WHERE RecordLastSeen >= ? AND RecordTimeInserted <= ? 
+		UNION SELECT ID_NodeFingerprints FROM Unreachable_or_addresses_v6 WHERE RecordLastSeen >= ? AND RecordTimeInserted <= ?) 
+		GROUP BY ID_NodeFingerprints);`
+	return db.SQLQueryKeyValue(query, from, to, from, to)
+}
+
+// Returns the TorRelays records valid at the time of the relays which had the Exit flag
+// and were present in the network then (relays without PresenceIntervals, e.g. imported
+// before they were recorded, are taken as present), with their exit policies and the exit and OR
+// addresses they used at the time (comma separated). The flags are those of the record.
+func (db *DB) GetExitRelaysAt(at string) [](map[string]string) {
+	ifPrintln(3, "func GetExitRelaysAt: "+at)
+	defer ifPrintln(3, "func GetExitRelaysAt: END")
+
+	return db.SQLQueryTYPEOfMaps("sliceOfMaps", `SELECT tr.ID ID, Fingerprint, Nickname, 
+		DATE_FORMAT( tr.RecordTimeInserted, "%Y-%m-%d %H:%i:%s") as RecordTimeInserted, 
+		DATE_FORMAT( tr.RecordLastSeen, "%Y-%m-%d %H:%i:%s") as RecordLastSeen, 
+		CONCAT(tr.ID_ExitPolicies, "/", tr.ID_ExitPolicySummaries, "/", tr.ID_ExitPolicyV6Summaries) as PolicyKey, 
+		ExitPolicy, ExitPolicySummary, ExitPolicyV6Summary, 
+		CONCAT_WS(",", 
+			(SELECT GROUP_CONCAT(INET_NTOA(ip4)) FROM Exit_addresses_v4 e WHERE e.ID_NodeFingerprints = tr.ID_NodeFingerprints AND e.RecordTimeInserted <= ? AND e.RecordLastSeen >= ?), 
+			(SELECT GROUP_CONCAT(INET6_NTOA(ip6)) FROM Exit_addresses_v6 e WHERE e.ID_NodeFingerprints = tr.ID_NodeFingerprints AND e.RecordTimeInserted <= ? AND e.RecordLastSeen >= ?)) as ExitAddresses, 
+		CONCAT_WS(",", 
+			(SELECT GROUP_CONCAT(INET_NTOA(ip4)) FROM Or_addresses_v4 o WHERE o.ID_NodeFingerprints = tr.ID_NodeFingerprints AND o.RecordTimeInserted <= ? AND o.RecordLastSeen >= ?), 
+			(SELECT GROUP_CONCAT(INET6_NTOA(ip6)) FROM Or_addresses_v6 o WHERE o.ID_NodeFingerprints = tr.ID_NodeFingerprints AND o.RecordTimeInserted <= ? AND o.RecordLastSeen >= ?)) as OrAddresses 
+		FROM TorRelays tr 
+		LEFT JOIN NodeFingerprints nf ON tr.ID_NodeFingerprints = nf.ID 
+		LEFT JOIN ExitPolicies ep ON tr.ID_ExitPolicies = ep.ID 
+		LEFT JOIN ExitPolicySummaries eps ON tr.ID_ExitPolicySummaries = eps.ID 
+		LEFT JOIN ExitPolicyV6Summaries eps6 ON tr.ID_ExitPolicyV6Summaries = eps6.ID 
+		WHERE tr.RecordTimeInserted <= ? AND tr.RecordLastSeen >= ? AND JSON_CONTAINS(tr.flags, '"Exit"') 
+		AND (NOT EXISTS (SELECT 1 FROM PresenceIntervals p WHERE p.ID_NodeFingerprints = tr.ID_NodeFingerprints) 
+		OR EXISTS (SELECT 1 FROM PresenceIntervals p WHERE p.ID_NodeFingerprints = tr.ID_NodeFingerprints AND p.IntervalStart <= ? AND p.IntervalEnd >= ?)) 
+		ORDER BY Fingerprint;`, at, at, at, at, at, at, at, at, at, at, at, at).([](map[string]string))
+}
+
+// Returns the network summaries of the snapshots acquired in the [from, to] time range
+func (db *DB) GetNetworkSummaries(from string, to string) [](map[string]string) {
+	ifPrintln(3, "func GetNetworkSummaries: "+from+" - "+to)
+	defer ifPrintln(3, "func GetNetworkSummaries: END")
+
+	return db.SQLQueryTYPEOfMaps("sliceOfMaps", `SELECT DATE_FORMAT( tq.AcquisitionTimestamp, "%Y-%m-%d %H:%i:%s") as AcquisitionTimestamp, 
+		ns.Relays, ns.Running, ns.Exits, ns.Guards, ns.AdvertisedBandwidth, ns.ExitAdvertisedBandwidth, 
+		ns.ConsensusWeight, ns.ExitConsensusWeight, ns.Flags, ns.Countries, ns.ASes, ns.Versions 
+		FROM NetworkSummaries ns JOIN TorQueries tq ON ns.ID_TorQueries = tq.ID 
+		WHERE tq.AcquisitionTimestamp BETWEEN ? AND ? ORDER BY tq.AcquisitionTimestamp;`, from, to).([](map[string]string))
+}
@@ -0,0 +1,233 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A database/sql driver which records the statements and their arguments and returns
+// no rows, so the lookups can be checked without a MySQL server
+type recordedQuery struct {
+	query string
+	args  []driver.Value
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+}
+
+var testRecorder = &recorder{}
+
+func init() {
+	sql.Register("store-recorder", testRecorder)
+}
+
+func (r *recorder) Open(name string) (driver.Conn, error) { return recConn{r}, nil }
+
+func (r *recorder) record(query string, args []driver.Value) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = append(r.queries, recordedQuery{query, args})
+}
+
+type recConn struct{ r *recorder }
+
+func (c recConn) Prepare(query string) (driver.Stmt, error) { return recStmt{c.r, query}, nil }
+func (c recConn) Close() error                              { return nil }
+func (c recConn) Begin() (driver.Tx, error)                 { return nil, errors.New("transactions not supported") }
+
+type recStmt struct {
+	r     *recorder
+	query string
+}
+
+func (s recStmt) Close() error  { return nil }
+func (s recStmt) NumInput() int { return -1 }
+func (s recStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.r.record(s.query, args)
+	return driver.RowsAffected(0), nil
+}
+func (s recStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.r.record(s.query, args)
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return []string{"key", "value"} }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newRecordingDB(t *testing.T) *DB {
+	dbh, err := sql.Open("store-recorder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRecorder.mu.Lock()
+	testRecorder.queries = nil
+	testRecorder.mu.Unlock()
+	return &DB{dbh: dbh, initialized: true}
+}
+
+func recordedQueries() []recordedQuery {
+	testRecorder.mu.Lock()
+	defer testRecorder.mu.Unlock()
+	return append([]recordedQuery(nil), testRecorder.queries...)
+}
+
+var hostileInputs = []string{
+	`' OR '1'='1`,
+	`"; DROP TABLE TorRelays; --`,
+	`1) OR (1=1`,
+	`\' OR 1=1 -- `,
+	"nul\x00byte",
+	`%_\`,
+	`*/ UNION SELECT User, Password FROM mysql.user /*`,
+	`relay.example.com' --`,
+	`0xDEADBEEF'); DELETE FROM Contacts; --`,
+}
+
+// Every value must be bound: absent from the statement text, one argument per placeholder
+func checkBound(t *testing.T, name string, input string) {
+	t.Helper()
+	for _, q := range recordedQueries() {
+		if strings.Contains(q.query, input) {
+			t.Errorf("%s(%q): input spliced into the SQL: %s", name, input, q.query)
+		}
+		if n := strings.Count(q.query, "?"); n != len(q.args) {
+			t.Errorf("%s(%q): %d placeholders, %d arguments: %s", name, input, n, len(q.args), q.query)
+		}
+	}
+}
+
+func argsContain(queries []recordedQuery, value string) bool {
+	for _, q := range queries {
+		for _, a := range q.args {
+			if s, ok := a.(string); ok && strings.Contains(s, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestLookupsBindHostileInputs(t *testing.T) {
+	lookups := map[string]func(db *DB, h string){
+		"GetLatestTRsIDsByCountryCode":   func(db *DB, h string) { db.GetLatestTRsIDsByCountryCode(h, h, h) },
+		"GetLatestTRsIDsByEmail":         func(db *DB, h string) { db.GetLatestTRsIDsByEmail(h, h, h) },
+		"GetLatestTRsIDsByContactDomain": func(db *DB, h string) { db.GetLatestTRsIDsByContactDomain(h, h, h) },
+		"GetLatestTRsIDsByPGP":           func(db *DB, h string) { db.GetLatestTRsIDsByPGP(h, h, h) },
+		"GetLatestTRsIDsByIP":            func(db *DB, h string) { db.GetLatestTRsIDsByIP(h, h, h) },
+		"GetLatestTRsIDsByHostName":      func(db *DB, h string) { db.GetLatestTRsIDsByHostName(h, h, h) },
+		"GetAddressIntervalsByIP":        func(db *DB, h string) { db.GetAddressIntervalsByIP("192.0.2.1", h, h, h) },
+		"GetAddressIntervalsByCIDR":      func(db *DB, h string) { db.GetAddressIntervalsByCIDR("2001:db8::/32", h, h, h) },
+		"GetTorRelaysByIDs":              func(db *DB, h string) { db.GetTorRelaysByIDs([]string{"1", h, "2"}) },
+		"GetPresenceIntervalsByFingerprint": func(db *DB, h string) {
+			db.GetPresenceIntervalsByFingerprint(h, h, h)
+		},
+		"GetRelayTimeline":          func(db *DB, h string) { db.GetRelayTimeline(h) },
+		"GetASIntervals":            func(db *DB, h string) { db.GetASIntervals(h, h, h) },
+		"GetLatestTRsIDsByVersion":  func(db *DB, h string) { db.GetLatestTRsIDsByVersion(h, h, h) },
+		"GetLatestTRsIDsByPlatform": func(db *DB, h string) { db.GetLatestTRsIDsByPlatform(h, h, h) },
+		"GetLatestTRsIDsByUnrecommendedVersion": func(db *DB, h string) {
+			db.GetLatestTRsIDsByUnrecommendedVersion(h, h)
+		},
+		"GetVersionDistribution": func(db *DB, h string) { db.GetVersionDistribution(h) },
+		"SearchContacts":         func(db *DB, h string) { db.SearchContacts(h, h, h) },
+		"GetExitRelaysAt":        func(db *DB, h string) { db.GetExitRelaysAt(h) },
+		"InitCaches":             func(db *DB, h string) { db.InitCaches(h) },
+		"GetLatestTRsIDsByNickname": func(db *DB, h string) {
+			for _, mode := range NicknameMatchModes {
+				db.GetLatestTRsIDsByNickname(h, mode, 2, h, h)
+			}
+		},
+	}
+
+	for name, lookup := range lookups {
+		for _, h := range hostileInputs {
+			db := newRecordingDB(t)
+			lookup(db, h)
+			checkBound(t, name, h)
+		}
+	}
+}
+
+func TestValidatedInputsDoNotReachTheDatabase(t *testing.T) {
+	for _, h := range hostileInputs {
+		db := newRecordingDB(t)
+		db.GetLatestTRsIDsByCountryCode(h, MinTime, MaxTime)
+		db.GetLatestTRsIDsByIP(h, MinTime, MaxTime)
+		db.GetAddressIntervalsByIP(h, "", "", "")
+		db.GetAddressIntervalsByCIDR(h, "", "", "")
+		db.GetTorRelaysByIDs([]string{h})
+		if q := recordedQueries(); len(q) > 0 {
+			t.Errorf("%q: invalid input queried the database: %s", h, q[0].query)
+		}
+	}
+}
+
+func TestValuesAreBoundAsArguments(t *testing.T) {
+	db := newRecordingDB(t)
+	db.GetLatestTRsIDsByCountryCode("de", MinTime, MaxTime)
+	db.GetLatestTRsIDsByEmail("o'brien@example.com", "2019-03-01 00:00:00", "2019-03-31 23:59:59")
+	db.InitCaches("20200101000000")
+	queries := recordedQueries()
+	for _, value := range []string{"de", "o'brien@example.com", "2019-03-01 00:00:00", "20200101000000"} {
+		if !argsContain(queries, value) {
+			t.Errorf("%q is not bound as an argument", value)
+		}
+	}
+}
+
+func TestLikePatternsEscapeWildcards(t *testing.T) {
+	db := newRecordingDB(t)
+	db.GetLatestTRsIDsByNickname(`a%b_c\`, "prefix", 0, "", "")
+	db.GetLatestTRsIDsByNickname(`a%b*c?`, "glob", 0, "", "")
+	db.GetASIntervals(`AS_100%`, "", "")
+	db.GetLatestTRsIDsByVersion(`0.4_5`, "", "")
+	db.GetLatestTRsIDsByPlatform(`100%`, "", "")
+	queries := recordedQueries()
+	for _, want := range []string{`a\%b\_c\\%`, `a\%b%c_`, `%AS\_100\%%`, `0.4\_5.%`, `%100\%%`} {
+		if !argsContain(queries, want) {
+			t.Errorf("LIKE pattern %q not found in the arguments", want)
+		}
+	}
+}
+
+func TestIDListsAreChunked(t *testing.T) {
+	db := newRecordingDB(t)
+	var ids []string
+	for i := 1; i <= 2*inListChunkSize+1; i++ {
+		ids = append(ids, strconv.Itoa(i))
+	}
+	db.GetTorRelaysByIDs(append(ids, "1); DROP TABLE TorRelays; --"))
+	queries := recordedQueries()
+	if len(queries) != 3 {
+		t.Fatalf("%d queries, want 3", len(queries))
+	}
+	total := 0
+	for _, q := range queries {
+		if len(q.args) > inListChunkSize {
+			t.Errorf("%d arguments in one query", len(q.args))
+		}
+		total += len(q.args)
+	}
+	if total != len(ids) {
+		t.Errorf("%d IDs bound, want %d", total, len(ids))
+	}
+	checkBound(t, "GetTorRelaysByIDs", "DROP TABLE")
+}
@@ -0,0 +1,115 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+)
+
+// A field which differs between two versions of a relay
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Compared fields, in reporting order. A new TorRelays record is inserted when any
+// of them changes; the addresses are only available from stored records (jsd).
+var relayDiffFields = []string{"Nickname", "Country", "City_name", "As", "Platform", "Version",
+	"Version_status", "Recommended_version", "Contact",
+	"Or_addresses", "Dir_address", "Exit_policy", "Exit_policy_summary", "Exit_policy_v6_summary",
+	"Location", "First_seen", "Last_changed_address_or_port"}
+
+// Coordinates are stored with 6 decimals; a missing location compares as 0,0
+func formatLocation(lat float64, lon float64) string {
+	return fmt.Sprintf("%.6f,%.6f", lat, lon)
+}
+
+// Comparable field values of relay details
+func RelayFieldValues(relay onionoo.TorRelayDetails) map[string]string {
+	js_exitp, _ := json.Marshal(relay.Exit_policy)
+	js_exitps, _ := json.Marshal(relay.Exit_policy_summary)
+	js_exitps6, _ := json.Marshal(relay.Exit_policy_v6_summary)
+
+	return map[string]string{
+		"Nickname":                     relay.Nickname,
+		"Country":                      relay.Country,
+		"City_name":                    relay.City_name,
+		"As":                           RelayAS(relay),
+		"Platform":                     relay.Platform,
+		"Version":                      relay.Version,
+		"Version_status":               relay.Version_status,
+		"Recommended_version":          recommendedVersionString(relay.Recommended_version),
+		"Contact":                      relay.Contact,
+		"Or_addresses":                 strings.Join(relay.Or_addresses, " "),
+		"Dir_address":                  relay.Dir_address,
+		"Exit_policy":                  string(js_exitp),
+		"Exit_policy_summary":          string(js_exitps),
+		"Exit_policy_v6_summary":       string(js_exitps6),
+		"Location":                     formatLocation(relay.Latitude, relay.Longitude),
+		"First_seen":                   relay.First_seen,
+		"Last_changed_address_or_port": relay.Last_changed_address_or_port,
+	}
+}
+
+// Comparable field values of a Latest Relay Data (LRD) cache entry. The cache holds
+// no addresses, so those are not compared.
+func LRDFieldValues(lrdfp map[string]string) map[string]string {
+	var lat, lon float64
+	fmt.Sscan(lrdfp["Latitude"], &lat)
+	fmt.Sscan(lrdfp["Longitude"], &lon)
+
+	return map[string]string{
+		"Nickname":                     lrdfp["Nickname"],
+		"Country":                      lrdfp["Country"],
+		"City_name":                    lrdfp["CityName"],
+		"As":                           asString(lrdfp["ID_AutonomousSystems"]),
+		"Platform":                     lrdfp["PlatformName"],
+		"Version":                      lrdfp["VersionName"],
+		"Version_status":               lrdfp["Version_status"],
+		"Recommended_version":          recommendedString(lrdfp["Recommended_version"]),
+		"Contact":                      lrdfp["ContactName"],
+		"Exit_policy":                  lrdfp["ExitPolicy"],
+		"Exit_policy_summary":          lrdfp["ExitPolicySummary"],
+		"Exit_policy_v6_summary":       lrdfp["ExitPolicyV6Summary"],
+		"Location":                     formatLocation(lat, lon),
+		"First_seen":                   lrdfp["First_seen"],
+		"Last_changed_address_or_port": lrdfp["Last_changed_address_or_port"],
+	}
+}
+
+// Fields which differ between old and new. Fields missing from either side are not
+// compared; contacts are compared case insensitively as in the Contacts table.
+func DiffRelayFields(old map[string]string, new map[string]string) []FieldChange {
+	var changes []FieldChange
+	for _, field := range relayDiffFields {
+		o, inOld := old[field]
+		n, inNew := new[field]
+		if !inOld || !inNew {
+			continue
+		}
+		if field == "Contact" {
+			if strings.ToLower(o) == strings.ToLower(n) {
+				continue
+			}
+		} else if o == n {
+			continue
+		}
+		changes = append(changes, FieldChange{field, o, n})
+	}
+	return changes
+}
+
+// Fields which differ between two versions of a relay
+func DiffRelays(old onionoo.TorRelayDetails, new onionoo.TorRelayDetails) []FieldChange {
+	return DiffRelayFields(RelayFieldValues(old), RelayFieldValues(new))
+}
@@ -0,0 +1,605 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+)
+
+// Portable history format: one HistoryRecord per line (NDJSON). Every record is a
+// TorRelays version of a relay with the lookups resolved, so the file does not depend
+// on the database IDs or schema. Timestamps are "YYYY-MM-DD hh:mm:ss" as in Onionoo.
+type HistoryRecord struct {
+	Fingerprint                  string            `json:"fingerprint"`
+	Record_time_inserted         string            `json:"record_time_inserted"` // First snapshot with this version of the relay
+	Record_last_seen             string            `json:"record_last_seen"`     // Last snapshot with this version of the relay
+	Nickname                     string            `json:"nickname"`
+	Country                      string            `json:"country,omitempty"`
+	Country_name                 string            `json:"country_name,omitempty"`
+	Region_name                  string            `json:"region_name,omitempty"`
+	City_name                    string            `json:"city_name,omitempty"`
+	Platform                     string            `json:"platform,omitempty"`
+	Version                      string            `json:"version,omitempty"`
+	Contact                      string            `json:"contact,omitempty"`
+	First_seen                   string            `json:"first_seen"`
+	Last_changed_address_or_port string            `json:"last_changed_address_or_port"`
+	Latitude                     *float64          `json:"latitude,omitempty"`
+	Longitude                    *float64          `json:"longitude,omitempty"`
+	Exit_policy                  json.RawMessage   `json:"exit_policy,omitempty"`
+	Exit_policy_summary          json.RawMessage   `json:"exit_policy_summary,omitempty"`
+	Exit_policy_v6_summary       json.RawMessage   `json:"exit_policy_v6_summary,omitempty"`
+	Flags                        json.RawMessage   `json:"flags,omitempty"`
+	Details                      json.RawMessage   `json:"details,omitempty"` // Remaining Onionoo relay fields
+	Addresses                    []HistoryAddress  `json:"addresses,omitempty"`
+	Host_names                   []HistoryHostName `json:"host_names,omitempty"`
+}
+
+// Address interval of a relay overlapping the record
+type HistoryAddress struct {
+	Role       string `json:"role"` // "or", "exit", "dir" or "unreachable-or"
+	Address    string `json:"address"`
+	Port       string `json:"port,omitempty"`
+	First_seen string `json:"first_seen"`
+	Last_seen  string `json:"last_seen"`
+}
+
+// Reverse DNS name interval of a relay overlapping the record
+type HistoryHostName struct {
+	Host_name  string `json:"host_name"`
+	Verified   bool   `json:"verified"`
+	First_seen string `json:"first_seen"`
+	Last_seen  string `json:"last_seen"`
+}
+
+// Address tables by role and IP version; exit addresses have no port
+type historyAddressTable struct {
+	role, table, ipColumn, ntoa, aton string
+	hasPort                           bool
+}
+
+var historyAddressTables = []historyAddressTable{
+	{"or", "Or_addresses_v4", "ip4", "INET_NTOA", "INET_ATON", true},
+	{"or", "Or_addresses_v6", "ip6", "INET6_NTOA", "INET6_ATON", true},
+	{"exit", "Exit_addresses_v4", "ip4", "INET_NTOA", "INET_ATON", false},
+	{"exit", "Exit_addresses_v6", "ip6", "INET6_NTOA", "INET6_ATON", false},
+	{"dir", "Dir_addresses_v4", "ip4", "INET_NTOA", "INET_ATON", true},
+	{"dir", "Dir_addresses_v6", "ip6", "INET6_NTOA", "INET6_ATON", true},
+	{"unreachable-or", "Unreachable_or_addresses_v4", "ip4", "INET_NTOA", "INET_ATON", true},
+	{"unreachable-or", "Unreachable_or_addresses_v6", "ip6", "INET6_NTOA", "INET6_ATON", true},
+}
+
+// Intervals are compared as strings; TimeFmt sorts chronologically
+func intervalsOverlap(from1, to1, from2, to2 string) bool {
+	return from1 <= to2 && from2 <= to1
+}
+
+// Returns the TorRelays records, overlapping the [from, to] time range, whose column is
+// one of values, merged into intervals of consecutive records of a relay with the same
+// value; oldest first. Each row holds Fingerprint, column, RecordTimeInserted,
+// RecordLastSeen and ID_TorRelays, the latest record of the interval. Only called with
+// constant column names.
+func (db *DB) getRecordIntervals(column string, values []interface{}, from string, to string) [](map[string]string) {
+	var rows [](map[string]string)
+	for len(values) > 0 {
+		n := len(values)
+		if n > inListChunkSize {
+			n = inListChunkSize
+		}
+		args := append([]interface{}{from, to}, values[:n]...)
+		rows = append(rows, db.SQLQueryTYPEOfMaps("sliceOfMaps",
+			`SELECT tr.ID ID_TorRelays, Fingerprint, tr.`+column+`,
+			DATE_FORMAT( tr.RecordTimeInserted, "%Y-%m-%d %H:%i:%s") as RecordTimeInserted,
+			DATE_FORMAT( tr.RecordLastSeen, "%Y-%m-%d %H:%i:%s") as RecordLastSeen,
+			(SELECT p.ID FROM TorRelays p WHERE p.ID_NodeFingerprints = tr.ID_NodeFingerprints
+				AND p.RecordTimeInserted < tr.RecordTimeInserted ORDER BY p.RecordTimeInserted DESC LIMIT 1) as PrevID
+			FROM TorRelays tr
+			LEFT JOIN NodeFingerprints nf ON tr.ID_NodeFingerprints = nf.ID
+			WHERE tr.RecordLastSeen >= ? AND tr.RecordTimeInserted <= ?
+			AND tr.`+column+` IN (?`+strings.Repeat(", ?", n-1)+`);`, args...).([](map[string]string))...)
+		values = values[n:]
+	}
+	return mergeRecordIntervals(rows, column)
+}
+
+// Merges the records of a relay following each other with the same value in column.
+// rows need PrevID, the record of the relay preceding each one.
+func mergeRecordIntervals(rows [](map[string]string), column string) [](map[string]string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i]["Fingerprint"] != rows[j]["Fingerprint"] {
+			return rows[i]["Fingerprint"] < rows[j]["Fingerprint"]
+		}
+		return rows[i]["RecordTimeInserted"] < rows[j]["RecordTimeInserted"]
+	})
+
+	intervals := make([](map[string]string), 0)
+	var last map[string]string
+	for _, row := range rows {
+		if last != nil && last["Fingerprint"] == row["Fingerprint"] && last[column] == row[column] &&
+			row["PrevID"] == last["ID_TorRelays"] {
+			last["RecordLastSeen"] = row["RecordLastSeen"]
+			last["ID_TorRelays"] = row["ID_TorRelays"]
+			continue
+		}
+		last = map[string]string{
+			column:               row[column],
+			"Fingerprint":        row["Fingerprint"],
+			"RecordTimeInserted": row["RecordTimeInserted"],
+			"RecordLastSeen":     row["RecordLastSeen"],
+			"ID_TorRelays":       row["ID_TorRelays"],
+		}
+		intervals = append(intervals, last)
+	}
+
+	sort.SliceStable(intervals, func(i, j int) bool {
+		return intervals[i]["RecordTimeInserted"] < intervals[j]["RecordTimeInserted"]
+	})
+	return intervals
+}
+
+func rawJSONOrNil(s sql.NullString) json.RawMessage {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	return json.RawMessage(s.String)
+}
+
+//***************************************************************************
+// Export
+
+// Loads all address and host name intervals of a relay
+func (db *DB) getRelayIntervals(fpid string) ([]HistoryAddress, []HistoryHostName) {
+	var addresses []HistoryAddress
+	for _, t := range historyAddressTables {
+		port := "''"
+		if t.hasPort {
+			port = "CAST(port AS CHAR)"
+		}
+		rows := db.SQLQueryTYPEOfMaps("sliceOfMaps", fmt.Sprintf(`SELECT %s(%s) ip, %s port,
+			DATE_FORMAT( RecordTimeInserted, "%%Y-%%m-%%d %%H:%%i:%%s") RecordTimeInserted,
+			DATE_FORMAT( RecordLastSeen, "%%Y-%%m-%%d %%H:%%i:%%s") RecordLastSeen
+			FROM %s WHERE ID_NodeFingerprints = ? ORDER BY RecordTimeInserted;`, t.ntoa, t.ipColumn, port, t.table),
+			fpid).([](map[string]string))
+		for _, r := range rows {
+			addresses = append(addresses, HistoryAddress{t.role, r["ip"], r["port"], r["RecordTimeInserted"], r["RecordLastSeen"]})
+		}
+	}
+
+	var hostNames []HistoryHostName
+	rows := db.SQLQueryTYPEOfMaps("sliceOfMaps", `SELECT HostName, Verified,
+		DATE_FORMAT( RecordTimeInserted, "%Y-%m-%d %H:%i:%s") RecordTimeInserted,
+		DATE_FORMAT( RecordLastSeen, "%Y-%m-%d %H:%i:%s") RecordLastSeen
+		FROM Relay_host_names rh LEFT JOIN HostNames h ON rh.ID_HostNames = h.ID
+		WHERE rh.ID_NodeFingerprints = ? ORDER BY RecordTimeInserted;`, fpid).([](map[string]string))
+	for _, r := range rows {
+		hostNames = append(hostNames, HistoryHostName{r["HostName"], r["Verified"] == "1", r["RecordTimeInserted"], r["RecordLastSeen"]})
+	}
+	return addresses, hostNames
+}
+
+// Streams every TorRelays record overlapping [from, to] to w, one JSON object per line.
+func (db *DB) ExportHistory(w io.Writer, from string, to string) int {
+	ifPrintln(2, "ExportHistory: "+from+" - "+to)
+	defer ifPrintln(2, "ExportHistory: END")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	enc := json.NewEncoder(w)
+	return db.scanHistoryRecords("RecordLastSeen >= ? AND RecordTimeInserted <= ?", []interface{}{from, to}, func(rec *HistoryRecord) {
+		if err := enc.Encode(rec); err != nil {
+			log.Fatal("ExportHistory: ", err)
+		}
+	})
+}
+
+// Every TorRelays version of a relay, oldest first, with the address and host name
+// intervals overlapping each version
+func (db *DB) GetRelayTimeline(fingerprint string) []HistoryRecord {
+	ifPrintln(3, "func GetRelayTimeline: "+fingerprint)
+	defer ifPrintln(3, "func GetRelayTimeline: END")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	var records []HistoryRecord
+	fingerprint = strings.ToUpper(strings.TrimSpace(fingerprint))
+	db.scanHistoryRecords("Fingerprint = ?", []interface{}{fingerprint}, func(rec *HistoryRecord) {
+		records = append(records, *rec)
+	})
+	return records
+}
+
+// The TorRelays version of a relay valid at the time, nil if the relay was not recorded then
+func (db *DB) GetRelayRecordAt(fingerprint string, at string) *HistoryRecord {
+	ifPrintln(3, "func GetRelayRecordAt: "+fingerprint+" @ "+at)
+	defer ifPrintln(3, "func GetRelayRecordAt: END")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	var record *HistoryRecord
+	fingerprint = strings.ToUpper(strings.TrimSpace(fingerprint))
+	db.scanHistoryRecords("Fingerprint = ? AND RecordTimeInserted <= ? AND RecordLastSeen >= ?", []interface{}{fingerprint, at, at}, func(rec *HistoryRecord) {
+		r := *rec
+		record = &r
+	})
+	return record
+}
+
+// Runs fn on every TorRelays record matching where, resolved into a HistoryRecord.
+// Records are ordered by relay, so the address intervals are loaded once per relay.
+func (db *DB) scanHistoryRecords(where string, args []interface{}, fn func(rec *HistoryRecord)) int {
+	rows, err := db.dbh.Query(`SELECT tr.ID_NodeFingerprints, Fingerprint,
+		DATE_FORMAT( RecordTimeInserted, "%Y-%m-%d %H:%i:%s"), DATE_FORMAT( RecordLastSeen, "%Y-%m-%d %H:%i:%s"),
+		Nickname, ID_Countries, CountryName, RegionName, CityName, PlatformName, VersionName, ContactName,
+		DATE_FORMAT( First_seen, "%Y-%m-%d %H:%i:%s"), DATE_FORMAT( Last_changed_address_or_port, "%Y-%m-%d %H:%i:%s"),
+		Latitude, Longitude, ExitPolicy, ExitPolicySummary, ExitPolicyV6Summary, flags, jsd
+		FROM TorRelays tr
+		LEFT JOIN NodeFingerprints nf ON tr.ID_NodeFingerprints = nf.ID
+		LEFT JOIN Countries cy ON tr.ID_Countries = cy.CC
+		LEFT JOIN Regions r ON tr.ID_Regions = r.ID
+		LEFT JOIN Cities c ON ID_Cities = c.ID
+		LEFT JOIN Platforms p ON ID_Platforms = p.ID
+		LEFT JOIN Versions v ON ID_Versions = v.ID
+		LEFT JOIN Contacts ct ON ID_Contacts = ct.ID
+		LEFT JOIN ExitPolicies ep ON ID_ExitPolicies = ep.ID
+		LEFT JOIN ExitPolicySummaries eps ON ID_ExitPolicySummaries = eps.ID
+		LEFT JOIN ExitPolicyV6Summaries eps6 ON ID_ExitPolicyV6Summaries = eps6.ID
+		WHERE `+where+`
+		ORDER BY tr.ID_NodeFingerprints, RecordTimeInserted;`, args...)
+	if err != nil {
+		panic("func scanHistoryRecords: " + err.Error())
+	}
+	defer rows.Close()
+
+	var lastFpid string
+	var addresses []HistoryAddress
+	var hostNames []HistoryHostName
+	count := 0
+	for rows.Next() {
+		var fpid string
+		var rec HistoryRecord
+		var country, countryName, region, city, platform, version, contact sql.NullString
+		var exitp, exitps, exitps6, flags, jsd sql.NullString
+		var latitude, longitude sql.NullFloat64
+		err = rows.Scan(&fpid, &rec.Fingerprint, &rec.Record_time_inserted, &rec.Record_last_seen,
+			&rec.Nickname, &country, &countryName, &region, &city, &platform, &version, &contact,
+			&rec.First_seen, &rec.Last_changed_address_or_port, &latitude, &longitude, &exitp, &exitps, &exitps6, &flags, &jsd)
+		if err != nil {
+			panic("func scanHistoryRecords: " + err.Error())
+		}
+		rec.Country, rec.Country_name, rec.Region_name, rec.City_name = country.String, countryName.String, region.String, city.String
+		rec.Platform, rec.Version, rec.Contact = platform.String, version.String, contact.String
+		rec.Exit_policy, rec.Exit_policy_summary, rec.Exit_policy_v6_summary = rawJSONOrNil(exitp), rawJSONOrNil(exitps), rawJSONOrNil(exitps6)
+		rec.Flags, rec.Details = rawJSONOrNil(flags), rawJSONOrNil(jsd)
+		if latitude.Valid && longitude.Valid {
+			rec.Latitude, rec.Longitude = &latitude.Float64, &longitude.Float64
+		}
+
+		if fpid != lastFpid {
+			addresses, hostNames = db.getRelayIntervals(fpid)
+			lastFpid = fpid
+		}
+		for _, a := range addresses {
+			if intervalsOverlap(a.First_seen, a.Last_seen, rec.Record_time_inserted, rec.Record_last_seen) {
+				rec.Addresses = append(rec.Addresses, a)
+			}
+		}
+		for _, h := range hostNames {
+			if intervalsOverlap(h.First_seen, h.Last_seen, rec.Record_time_inserted, rec.Record_last_seen) {
+				rec.Host_names = append(rec.Host_names, h)
+			}
+		}
+
+		fn(&rec)
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		panic("func scanHistoryRecords: " + err.Error())
+	}
+	return count
+}
+
+// Rebuilds the complete Onionoo relay details of the record: the stored details (jsd)
+// merged back with the fields removed before storage. Last_seen is the last snapshot
+// with this version of the relay.
+func (rec *HistoryRecord) RelayDetails() (onionoo.TorRelayDetails, error) {
+	var relay onionoo.TorRelayDetails
+	if len(rec.Details) > 0 {
+		if err := json.Unmarshal(rec.Details, &relay); err != nil {
+			return relay, err
+		}
+	}
+	relay.Fingerprint = rec.Fingerprint
+	relay.Nickname = rec.Nickname
+	relay.Country, relay.Country_name = rec.Country, rec.Country_name
+	relay.Region_name, relay.City_name = rec.Region_name, rec.City_name
+	relay.Platform, relay.Version, relay.Contact = rec.Platform, rec.Version, rec.Contact
+	relay.First_seen = rec.First_seen
+	relay.Last_changed_address_or_port = rec.Last_changed_address_or_port
+	relay.Last_seen = rec.Record_last_seen
+	if rec.Latitude != nil && rec.Longitude != nil {
+		relay.Latitude, relay.Longitude = *rec.Latitude, *rec.Longitude
+	}
+	if len(rec.Flags) > 0 {
+		relay.Flags = nil
+		if err := json.Unmarshal(rec.Flags, &relay.Flags); err != nil {
+			return relay, err
+		}
+	}
+	if len(rec.Exit_policy) > 0 {
+		if err := json.Unmarshal(rec.Exit_policy, &relay.Exit_policy); err != nil {
+			return relay, err
+		}
+	}
+	if len(rec.Exit_policy_summary) > 0 {
+		if err := json.Unmarshal(rec.Exit_policy_summary, &relay.Exit_policy_summary); err != nil {
+			return relay, err
+		}
+	}
+	if len(rec.Exit_policy_v6_summary) > 0 {
+		if err := json.Unmarshal(rec.Exit_policy_v6_summary, &relay.Exit_policy_v6_summary); err != nil {
+			return relay, err
+		}
+	}
+	return relay, nil
+}
+
+//***************************************************************************
+// Import
+
+func rowExists(tx *sql.Tx, query string, args ...interface{}) bool {
+	var count int
+	if err := tx.QueryRow(query, args...).Scan(&count); err != nil {
+		panic("func rowExists: " + err.Error())
+	}
+	return count > 0
+}
+
+// Inserts a history record unless a record of the same relay starting at the same
+// time is already present. Address and host name intervals are deduplicated the same
+// way, as the export repeats them in every record they overlap.
+func (db *DB) importHistoryRecord(tx *sql.Tx, fpid string, rec *HistoryRecord) bool {
+	ifPrintln(4, "importHistoryRecord: "+rec.Fingerprint+" "+rec.Record_time_inserted)
+
+	inserted := false
+	if !rowExists(tx, "SELECT COUNT(*) FROM TorRelays WHERE ID_NodeFingerprints = ? AND RecordTimeInserted = ?;", fpid, rec.Record_time_inserted) {
+		countryid := db.NormalizeCountryID(rec.Country, rec.Country_name)
+		regionid := db.Value2ID("region", rec.Region_name)
+		cityid := db.Value2ID("city", rec.City_name)
+		platformid := db.Value2ID("platform", rec.Platform)
+		versionid := db.Value2ID("version", rec.Version)
+		contactid := db.Value2ID("contact", rec.Contact)
+		exitp := db.Value2ID("exitp", historyRawString(rec.Exit_policy))
+		exitps := db.Value2ID("exitps", historyRawString(rec.Exit_policy_summary))
+		exitps6 := db.Value2ID("exitps6", historyRawString(rec.Exit_policy_v6_summary))
+		var latitude, longitude interface{}
+		if rec.Latitude != nil && rec.Longitude != nil {
+			latitude, longitude = *rec.Latitude, *rec.Longitude
+		}
+
+		// The AS and the version status are read from the details; invalid details leave them NULL
+		details, _ := rec.RelayDetails()
+		asNumber := db.NormalizeASNumber(RelayAS(details), details.As_name)
+		versionStatus, recommended := VersionStatusValues(details)
+
+		_, err := tx.Stmt(db.stmtAddTorRelays).Exec(fpid, countryid, regionid, cityid, asNumber, platformid, versionid, versionStatus, recommended, contactid,
+			exitp, exitps, exitps6, rec.Nickname, rec.Last_changed_address_or_port, rec.First_seen,
+			rec.Record_time_inserted, rec.Record_last_seen, latitude, longitude, historyRawString(rec.Flags), historyRawString(rec.Details))
+		if err != nil {
+			panic("func importHistoryRecord: " + err.Error())
+		}
+		inserted = true
+	}
+
+	for _, a := range rec.Addresses {
+		db.importHistoryAddress(tx, fpid, a)
+	}
+	for _, h := range rec.Host_names {
+		hnid := db.Value2ID("hostname", NormalizeHostName(h.Host_name))
+		if rowExists(tx, "SELECT COUNT(*) FROM Relay_host_names WHERE ID_NodeFingerprints = ? AND ID_HostNames = ? AND RecordTimeInserted = ?;", fpid, hnid, h.First_seen) {
+			continue
+		}
+		if _, err := tx.Stmt(db.stmtAddRelayHostName).Exec(fpid, hnid, h.Verified, h.First_seen, h.Last_seen); err != nil {
+			panic("func importHistoryRecord: " + err.Error())
+		}
+	}
+	return inserted
+}
+
+func (db *DB) importHistoryAddress(tx *sql.Tx, fpid string, a HistoryAddress) {
+	ip := net.ParseIP(a.Address)
+	if ip == nil {
+		ifPrintln(-1, "importHistoryAddress: skipping invalid address: "+a.Address)
+		return
+	}
+	v6 := ip.To4() == nil
+
+	var stmt *sql.Stmt
+	switch {
+	case a.Role == "or" && !v6:
+		stmt = db.stmtAddOrV4
+	case a.Role == "or" && v6:
+		stmt = db.stmtAddOrV6
+	case a.Role == "exit" && !v6:
+		stmt = db.stmtAddExitV4
+	case a.Role == "exit" && v6:
+		stmt = db.stmtAddExitV6
+	case a.Role == "dir" && !v6:
+		stmt = db.stmtAddDirV4
+	case a.Role == "dir" && v6:
+		stmt = db.stmtAddDirV6
+	case a.Role == "unreachable-or" && !v6:
+		stmt = db.stmtAddUnV4
+	case a.Role == "unreachable-or" && v6:
+		stmt = db.stmtAddUnV6
+	default:
+		ifPrintln(-1, "importHistoryAddress: skipping unknown address role: "+a.Role)
+		return
+	}
+
+	for _, t := range historyAddressTables {
+		if t.role != a.Role || (t.ipColumn == "ip6") != v6 {
+			continue
+		}
+		if rowExists(tx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE ID_NodeFingerprints = ? AND %s = %s(?) AND RecordTimeInserted = ?;", t.table, t.ipColumn, t.aton),
+			fpid, a.Address, a.First_seen) {
+			return
+		}
+	}
+
+	var err error
+	if a.Role == "exit" {
+		_, err = tx.Stmt(stmt).Exec(fpid, a.First_seen, a.Last_seen, a.Address)
+	} else {
+		_, err = tx.Stmt(stmt).Exec(fpid, a.First_seen, a.Last_seen, a.Address, a.Port)
+	}
+	if err != nil {
+		panic("func importHistoryAddress: " + err.Error())
+	}
+}
+
+// Lookup values are stored as JSON text: "null" stands for a missing value, as produced by json.Marshal
+func historyRawString(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return "null"
+	}
+	return string(raw)
+}
+
+// Joins the overlapping intervals ([start, end] pairs), and the ones following each
+// other without a snapshot in between
+func mergePresenceIntervals(intervals [][2]string, snapshotBetween func(end string, start string) bool) [][2]string {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i][0] < intervals[j][0] })
+	var merged [][2]string
+	for _, in := range intervals {
+		if n := len(merged); n > 0 && (in[0] <= merged[n-1][1] || !snapshotBetween(merged[n-1][1], in[0])) {
+			if in[1] > merged[n-1][1] {
+				merged[n-1][1] = in[1]
+			}
+			continue
+		}
+		merged = append(merged, in)
+	}
+	return merged
+}
+
+// Replaces the presence intervals of a relay with their union with the imported records
+func (db *DB) rebuildPresenceIntervals(tx *sql.Tx, fpid string, records [][2]string) {
+	rows, err := tx.Query(`SELECT DATE_FORMAT( IntervalStart, "%Y-%m-%d %H:%i:%s"), DATE_FORMAT( IntervalEnd, "%Y-%m-%d %H:%i:%s")
+		FROM PresenceIntervals WHERE ID_NodeFingerprints = ?;`, fpid)
+	if err != nil {
+		panic("func rebuildPresenceIntervals: " + err.Error())
+	}
+	intervals := append([][2]string(nil), records...)
+	for rows.Next() {
+		var in [2]string
+		if err = rows.Scan(&in[0], &in[1]); err != nil {
+			panic("func rebuildPresenceIntervals: " + err.Error())
+		}
+		intervals = append(intervals, in)
+	}
+	if err = rows.Err(); err != nil {
+		panic("func rebuildPresenceIntervals: " + err.Error())
+	}
+	rows.Close()
+
+	merged := mergePresenceIntervals(intervals, func(end string, start string) bool {
+		return rowExists(tx, "SELECT COUNT(*) FROM TorQueries WHERE AcquisitionTimestamp > ? AND AcquisitionTimestamp < ?;", end, start)
+	})
+	if _, err = tx.Exec("DELETE FROM PresenceIntervals WHERE ID_NodeFingerprints = ?;", fpid); err != nil {
+		panic("func rebuildPresenceIntervals: " + err.Error())
+	}
+	for _, in := range merged {
+		if _, err = tx.Stmt(db.stmtAddPresence).Exec(fpid, in[0], in[1]); err != nil {
+			panic("func rebuildPresenceIntervals: " + err.Error())
+		}
+	}
+}
+
+// Imports the records of an export overlapping [from, to] in a single transaction.
+// An export has no snapshots: the first and last snapshots of the records are logged
+// in TorQueries when missing (without a version nor a network summary), and the
+// presence intervals of the relays are extended over their records. The lookup values
+// (contacts, platforms...) are added outside of the transaction, like during an import.
+func (db *DB) ImportHistory(r io.Reader, from string, to string) (int, int) {
+	ifPrintln(2, "ImportHistory: "+from+" - "+to)
+	defer ifPrintln(2, "ImportHistory: END")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	tx, err := db.dbh.Begin()
+	if err != nil {
+		panic("func ImportHistory: " + err.Error())
+	}
+	defer tx.Rollback() // No-op after a successful commit
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024) // Records with long exit policies exceed the default
+	read, inserted := 0, 0
+	presence := make(map[string]([][2]string))
+	snapshots := make(map[string]bool)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		var rec HistoryRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			tx.Rollback()
+			log.Fatalf("ImportHistory: line %d: %s", read+1, err)
+		}
+		read++
+		if !intervalsOverlap(rec.Record_time_inserted, rec.Record_last_seen, from, to) {
+			continue
+		}
+		fpid := db.Value2ID("fingerprint", rec.Fingerprint)
+		if db.importHistoryRecord(tx, fpid, &rec) {
+			inserted++
+		}
+		presence[fpid] = append(presence[fpid], [2]string{rec.Record_time_inserted, rec.Record_last_seen})
+		snapshots[rec.Record_time_inserted] = true
+		snapshots[rec.Record_last_seen] = true
+	}
+	if err := scanner.Err(); err != nil {
+		tx.Rollback()
+		log.Fatal("ImportHistory: ", err)
+	}
+
+	for ts := range snapshots {
+		if rowExists(tx, "SELECT COUNT(*) FROM TorQueries WHERE AcquisitionTimestamp = ?;", ts) {
+			continue
+		}
+		if _, err = tx.Stmt(db.stmtTorQueries).Exec("", ts, ts, ts); err != nil {
+			panic("func ImportHistory: " + err.Error())
+		}
+	}
+	for fpid, records := range presence {
+		db.rebuildPresenceIntervals(tx, fpid, records)
+	}
+
+	if err = tx.Commit(); err != nil {
+		panic("func ImportHistory: commit: " + err.Error())
+	}
+	return read, inserted
+}
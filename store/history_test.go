@@ -0,0 +1,67 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeRecordIntervals(t *testing.T) {
+	row := func(id, prev, fp, value, from, to string) map[string]string {
+		return map[string]string{"ID_TorRelays": id, "PrevID": prev, "Fingerprint": fp, "ID_Contacts": value,
+			"RecordTimeInserted": from, "RecordLastSeen": to}
+	}
+	intervals := mergeRecordIntervals([](map[string]string){
+		row("3", "2", "A", "1", "2020-03-01", "2020-03-31"),
+		row("1", "", "A", "1", "2020-01-01", "2020-01-31"),
+		row("2", "1", "A", "1", "2020-02-01", "2020-02-29"),
+		row("5", "4", "A", "1", "2020-05-01", "2020-05-31"), // record 4 had another value
+		row("7", "", "B", "1", "2020-01-15", "2020-06-30"),
+	}, "ID_Contacts")
+
+	want := []struct{ fp, from, to, id string }{
+		{"A", "2020-01-01", "2020-03-31", "3"},
+		{"B", "2020-01-15", "2020-06-30", "7"},
+		{"A", "2020-05-01", "2020-05-31", "5"},
+	}
+	if len(intervals) != len(want) {
+		t.Fatalf("%d intervals, want %d: %v", len(intervals), len(want), intervals)
+	}
+	for i, w := range want {
+		got := intervals[i]
+		if got["Fingerprint"] != w.fp || got["RecordTimeInserted"] != w.from || got["RecordLastSeen"] != w.to || got["ID_TorRelays"] != w.id {
+			t.Errorf("interval %d = %v, want %v", i, got, w)
+		}
+	}
+}
+
+func TestMergePresenceIntervals(t *testing.T) {
+	snapshots := []string{"2020-01-10", "2020-02-10", "2020-02-25"} // Between the intervals below
+	snapshotBetween := func(end string, start string) bool {
+		for _, s := range snapshots {
+			if end < s && s < start {
+				return true
+			}
+		}
+		return false
+	}
+	merged := mergePresenceIntervals([][2]string{
+		{"2020-03-01", "2020-03-31"},
+		{"2020-01-01", "2020-01-05"},
+		{"2020-01-06", "2020-01-09"}, // No snapshot since the previous one: joined
+		{"2020-01-20", "2020-02-15"}, // After the 2020-01-10 snapshot
+		{"2020-02-01", "2020-02-05"}, // Inside the previous one
+		{"2020-02-14", "2020-02-20"}, // Overlaps the previous one
+	}, snapshotBetween)
+
+	want := [][2]string{{"2020-01-01", "2020-01-09"}, {"2020-01-20", "2020-02-20"}, {"2020-03-01", "2020-03-31"}}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("got %v, want %v", merged, want)
+	}
+}
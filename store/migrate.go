@@ -0,0 +1,149 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/Harsh-bartariya/tor-history/config"
+)
+
+// A schema upgrade. It is applied when the table (or its column or index, if set)
+// is missing, which makes running the migrations again a no-op.
+type Migration struct {
+	description string
+	table       string
+	column      string
+	index       string
+	statements  []string
+}
+
+// Upgrades of the existing tables, in order. They follow sql-upgrade.sql.
+var schemaUpgrades = []Migration{
+	{
+		description: "geo location of the relays",
+		table:       "TorRelays", column: "Latitude",
+		statements: []string{`ALTER TABLE TorRelays
+			ADD COLUMN Latitude DECIMAL(9,6) AFTER First_seen,
+			ADD COLUMN Longitude DECIMAL(9,6) AFTER Latitude,
+			ADD INDEX geo (Latitude, Longitude);`,
+			`UPDATE TorRelays SET Latitude = jsd->>'$.Latitude', Longitude = jsd->>'$.Longitude'
+			WHERE jsd->>'$.Latitude' IS NOT NULL;`},
+	},
+	{
+		description: "autonomous system of the relays",
+		table:       "TorRelays", column: "ID_AutonomousSystems",
+		statements: []string{`ALTER TABLE TorRelays
+			ADD COLUMN ID_AutonomousSystems INT UNSIGNED AFTER ID_Cities,
+			ADD INDEX as_time (ID_AutonomousSystems, RecordLastSeen);`,
+			`UPDATE TorRelays SET ID_AutonomousSystems = CAST(SUBSTRING(COALESCE(jsd->>'$.As', jsd->>'$.As_number'), 3) AS UNSIGNED)
+			WHERE COALESCE(jsd->>'$.As', jsd->>'$.As_number') LIKE 'AS%';`,
+			`INSERT INTO AutonomousSystems (ASNumber, ASName)
+			SELECT ID_AutonomousSystems, MAX(jsd->>'$.As_name') FROM TorRelays
+			WHERE ID_AutonomousSystems IS NOT NULL AND jsd->>'$.As_name' IS NOT NULL GROUP BY ID_AutonomousSystems
+			ON DUPLICATE KEY UPDATE ASName = VALUES(ASName);`},
+	},
+	{
+		description: "Tor version status of the relays",
+		table:       "TorRelays", column: "Version_status",
+		statements: []string{`ALTER TABLE TorRelays
+			ADD COLUMN Version_status CHAR(13) AFTER ID_Versions,
+			ADD COLUMN Recommended_version BOOLEAN AFTER Version_status,
+			ADD INDEX platform_time (ID_Platforms, RecordLastSeen),
+			ADD INDEX version_time (ID_Versions, RecordLastSeen);`,
+			`UPDATE TorRelays SET Version_status = jsd->>'$.Version_status',
+			Recommended_version = CASE jsd->>'$.Recommended_version' WHEN 'true' THEN TRUE WHEN 'false' THEN FALSE END;`,
+			`UPDATE TorRelays SET Recommended_version = (Version_status = 'recommended')
+			WHERE Recommended_version IS NULL AND Version_status IS NOT NULL;`},
+	},
+	{
+		description: "full-text index of the contacts",
+		table:       "Contacts", index: "contact_ft",
+		statements: []string{`ALTER TABLE Contacts ADD FULLTEXT INDEX contact_ft (ContactName);`},
+	},
+	{
+		description: "contact index of the relays",
+		table:       "TorRelays", index: "contact_time",
+		statements: []string{`ALTER TABLE TorRelays ADD INDEX contact_time (ID_Contacts, RecordLastSeen);`},
+	},
+}
+
+var createTableRE = regexp.MustCompile(`(?s)CREATE TABLE\s+(\w+)\s*\(.*?\n\);`)
+
+// Migrations bringing a database to the schema: the CREATE TABLE statements of the
+// schema (sql-schema.sql) for the missing tables, then the upgrades of the existing ones
+func BuildMigrations(schema string) []Migration {
+	var migrations []Migration
+	for _, m := range createTableRE.FindAllStringSubmatch(schema, -1) {
+		migrations = append(migrations, Migration{
+			description: "table " + m[1],
+			table:       m[1],
+			statements:  []string{m[0]},
+		})
+	}
+	return append(migrations, schemaUpgrades...)
+}
+
+// Whether the schema object added by the migration exists
+func (m Migration) applied(dbh *sql.DB) bool {
+	query, args := "SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", []interface{}{m.table}
+	if m.column != "" {
+		query, args = "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", []interface{}{m.table, m.column}
+	} else if m.index != "" {
+		query, args = "SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?", []interface{}{m.table, m.index}
+	}
+	var count int
+	if err := dbh.QueryRow(query, args...).Scan(&count); err != nil {
+		panic("func Migrate: (" + m.description + ") " + err.Error())
+	}
+	return count > 0
+}
+
+// Applies the missing migrations, in order, and returns their number. It uses its own
+// connection, as NewDB cannot prepare its statements before the schema is upgraded.
+// In dry run mode the missing migrations are only listed. Needs CREATE, ALTER, INDEX
+// and UPDATE privileges; the grants of new tables are left to the administrator.
+func Migrate(cfg config.TorHistoryConfig, migrations []Migration, dryRun bool) int {
+	ifPrintln(3, "func Migrate: START")
+	defer ifPrintln(3, "func Migrate: END")
+
+	dbh, err := sql.Open("mysql", connString(cfg.DBServer.Username, cfg.DBServer.Password, cfg.DBServer.Host, cfg.DBServer.Port, cfg.DBServer.DBName))
+	if err != nil {
+		panic("func Migrate: " + err.Error())
+	}
+	defer dbh.Close()
+
+	pending := 0
+	for _, m := range migrations {
+		if m.applied(dbh) {
+			ifPrintln(2, "Already applied: "+m.description)
+			continue
+		}
+		pending++
+		if dryRun {
+			fmt.Println("Pending: " + m.description)
+			continue
+		}
+		fmt.Println("Applying: " + m.description)
+		// MySQL commits DDL statements implicitly, so there is no transaction
+		for _, stmt := range m.statements {
+			if _, err := dbh.Exec(stmt); err != nil {
+				panic("func Migrate: (" + m.description + ") " + err.Error())
+			}
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("Dry run: %d migrations would be applied.\n", pending)
+	} else {
+		fmt.Printf("Applied %d migrations.\n", pending)
+	}
+	return pending
+}
@@ -0,0 +1,51 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// The upgrades must lead to the schema: every column and index they add is part of
+// the CREATE TABLE statement of sql-schema.sql
+func TestMigrationsMatchSchema(t *testing.T) {
+	schema, err := ioutil.ReadFile("../sql-schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations := BuildMigrations(string(schema))
+
+	creates := make(map[string]string)
+	for _, m := range migrations {
+		if m.column == "" && m.index == "" {
+			creates[m.table] = m.statements[0]
+		}
+	}
+	if n := strings.Count(string(schema), "CREATE TABLE"); len(creates) != n {
+		t.Fatalf("found %d tables, the schema has %d", len(creates), n)
+	}
+	if len(migrations) != len(creates)+len(schemaUpgrades) {
+		t.Fatalf("got %d migrations, want %d", len(migrations), len(creates)+len(schemaUpgrades))
+	}
+
+	for _, m := range schemaUpgrades {
+		create, ok := creates[m.table]
+		if !ok {
+			t.Errorf("%s: table %s is not in the schema", m.description, m.table)
+			continue
+		}
+		if m.column != "" && !strings.Contains(create, "\t"+m.column+" ") {
+			t.Errorf("%s: column %s is not in the schema of %s", m.description, m.column, m.table)
+		}
+		if m.index != "" && !strings.Contains(create, "INDEX "+m.index+" ") {
+			t.Errorf("%s: index %s is not in the schema of %s", m.description, m.index, m.table)
+		}
+	}
+}
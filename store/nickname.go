@@ -0,0 +1,122 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Nickname match modes:
+//
+//	exact:  the nickname (case insensitive with the default collation)
+//	prefix: nicknames starting with the pattern
+//	glob:   "*" matches any run of characters and "?" a single one
+//	regex:  Go regular expression, e.g. "(?i)^relay[0-9]+$"
+//	fuzzy:  nicknames within the edit distance of the pattern (case insensitive)
+var NicknameMatchModes = []string{"exact", "prefix", "glob", "regex", "fuzzy"}
+
+// Returns the latest TorRelay record, within the [from, to] time range, of every relay
+// which used a nickname matching the pattern in that range
+func (db *DB) GetLatestTRsIDsByNickname(pattern string, mode string, maxDistance int, from string, to string) (map[string]string, error) {
+	ifPrintln(3, "func GetLatestTRsIDsByNickname: ("+mode+") "+pattern+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByNickname: END")
+
+	const latest = `SELECT ID, ID_NodeFingerprints FROM TorRelays WHERE (ID_NodeFingerprints, RecordLastSeen) IN 
+		(SELECT ID_NodeFingerprints, max(RecordLastSeen) FROM TorRelays WHERE RecordLastSeen >= ? AND RecordTimeInserted <= ? AND `
+
+	switch mode {
+	case "exact":
+		return db.SQLQueryKeyValue(latest+`Nickname = ? GROUP BY ID_NodeFingerprints);`, from, to, pattern), nil
+	case "prefix":
+		return db.SQLQueryKeyValue(latest+`Nickname LIKE ? GROUP BY ID_NodeFingerprints);`, from, to, db.escapeLikeWildcards(pattern)+"%"), nil
+	case "glob":
+		like := strings.NewReplacer("*", "%", "?", "_").Replace(db.escapeLikeWildcards(pattern))
+		return db.SQLQueryKeyValue(latest+`Nickname LIKE ? GROUP BY ID_NodeFingerprints);`, from, to, like), nil
+	case "regex", "fuzzy":
+		// Matched here rather than in MySQL: its REGEXP dialect differs and it has no edit distance
+		var match func(string) bool
+		if mode == "regex" {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			match = re.MatchString
+		} else {
+			lower := strings.ToLower(pattern)
+			match = func(nickname string) bool {
+				return editDistance(lower, strings.ToLower(nickname)) <= maxDistance
+			}
+		}
+
+		var nicknames []interface{}
+		for _, row := range db.SQLQueryTYPEOfMaps("sliceOfMaps", `SELECT DISTINCT Nickname FROM TorRelays 
+			WHERE RecordLastSeen >= ? AND RecordTimeInserted <= ?;`, from, to).([](map[string]string)) {
+			if match(row["Nickname"]) {
+				nicknames = append(nicknames, row["Nickname"])
+			}
+		}
+
+		// A relay can match with several nicknames; keep its latest record
+		latestByFpid := make(map[string]int)
+		for len(nicknames) > 0 {
+			n := len(nicknames)
+			if n > inListChunkSize {
+				n = inListChunkSize
+			}
+			args := append([]interface{}{from, to}, nicknames[:n]...)
+			query := latest + `Nickname IN (?` + strings.Repeat(", ?", n-1) + `) GROUP BY ID_NodeFingerprints);`
+			for id, fpid := range db.SQLQueryKeyValue(query, args...) {
+				if trid, _ := strconv.Atoi(id); trid > latestByFpid[fpid] {
+					latestByFpid[fpid] = trid
+				}
+			}
+			nicknames = nicknames[n:]
+		}
+		result := make(map[string]string)
+		for fpid, trid := range latestByFpid {
+			result[strconv.Itoa(trid)] = fpid
+		}
+		return result, nil
+	}
+	return nil, errors.New("unknown nickname match mode: " + mode)
+}
+
+// Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
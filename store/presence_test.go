@@ -0,0 +1,57 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func presenceInterval(id string, start string, end string) map[string]string {
+	return map[string]string{"ID": id, "IntervalStart": start, "IntervalEnd": end}
+}
+
+// The snapshot at 02:00 is imported between the ones at 01:00 and 03:00
+func TestPlanPresenceChanges(t *testing.T) {
+	const prev, dlts, next = "20190301010000", "20190301020000", "20190301030000"
+	ending := presenceInterval("10", "20190301000000", prev)
+	starting := presenceInterval("11", next, "20190301050000")
+	spanning := presenceInterval("12", prev, next)
+
+	for _, tc := range []struct {
+		name      string
+		present   map[string]bool
+		intervals map[string](map[string](map[string]string))
+		prev      string
+		next      string
+		want      []presenceChange
+	}{
+		{"new interval", map[string]bool{"1": true}, nil, prev, next,
+			[]presenceChange{{op: "add", fpid: "1", start: dlts, end: dlts}}},
+		{"new interval, first snapshot", map[string]bool{"1": true}, nil, "", "",
+			[]presenceChange{{op: "add", fpid: "1", start: dlts, end: dlts}}},
+		{"extend after", map[string]bool{"1": true}, map[string](map[string](map[string]string)){"1": {"10": ending}}, prev, next,
+			[]presenceChange{{op: "end", fpid: "1", id: "10", end: dlts}}},
+		{"extend before", map[string]bool{"1": true}, map[string](map[string](map[string]string)){"1": {"11": starting}}, prev, next,
+			[]presenceChange{{op: "start", fpid: "1", id: "11", start: dlts}}},
+		{"fill gap", map[string]bool{"1": true}, map[string](map[string](map[string]string)){"1": {"10": ending, "11": starting}}, prev, next,
+			[]presenceChange{{op: "end", fpid: "1", id: "10", end: "20190301050000"}, {op: "delete", fpid: "1", id: "11"}}},
+		{"already accounted", map[string]bool{"1": true}, map[string](map[string](map[string]string)){"1": {"12": spanning}}, prev, next,
+			nil},
+		{"split", map[string]bool{}, map[string](map[string](map[string]string)){"2": {"12": spanning}}, prev, next,
+			[]presenceChange{{op: "end", fpid: "2", id: "12", end: prev}, {op: "add", fpid: "2", start: next, end: next}}},
+		{"absent, not spanning", map[string]bool{"1": false}, map[string](map[string](map[string]string)){"1": {"10": ending, "11": starting}}, prev, next,
+			nil},
+		{"split and extend", map[string]bool{"1": true}, map[string](map[string](map[string]string)){"1": {"10": ending}, "2": {"12": spanning}}, prev, next,
+			[]presenceChange{{op: "end", fpid: "2", id: "12", end: prev}, {op: "add", fpid: "2", start: next, end: next}, {op: "end", fpid: "1", id: "10", end: dlts}}},
+	} {
+		if got := planPresenceChanges(tc.present, tc.intervals, dlts, tc.prev, tc.next); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// A retention rule: rows of table matching the where clause are removed
+type PrunePolicy struct {
+	description string
+	table       string
+	where       string
+	args        []interface{}
+}
+
+// Lookup tables and the column referencing them. Rows which are no longer
+// referenced are garbage left behind after older records are removed.
+var pruneLookupTables = []struct{ table, refTable, refColumn string }{
+	{"Contacts", "TorRelays", "ID_Contacts"},
+	{"Platforms", "TorRelays", "ID_Platforms"},
+	{"Versions", "TorRelays", "ID_Versions"},
+	{"Regions", "TorRelays", "ID_Regions"},
+	{"Cities", "TorRelays", "ID_Cities"},
+	{"ExitPolicies", "TorRelays", "ID_ExitPolicies"},
+	{"ExitPolicySummaries", "TorRelays", "ID_ExitPolicySummaries"},
+	{"ExitPolicyV6Summaries", "TorRelays", "ID_ExitPolicyV6Summaries"},
+	{"HostNames", "Relay_host_names", "ID_HostNames"},
+}
+
+// Tables holding details of the rows of a lookup table, removed with them
+var pruneDetailTables = []struct{ table, column, lookupTable string }{
+	{"ContactDetails", "ID_Contacts", "Contacts"},
+}
+
+// Converts "N months ago" to a DLTS formatted timestamp
+func monthsAgoDLTS(months int) string {
+	return time.Now().AddDate(0, -months, 0).Format("20060102150405")
+}
+
+// Policies removing the snapshots (TorQueries) older than metricsMonths, keeping
+// one snapshot a day of those older than downsampleMonths, and with gc the orphaned
+// lookup rows. The uptime (GetRelayUptime) counts the remaining snapshots only, and
+// presence intervals of a later import into a pruned period span the removed
+// snapshots, as the neighbouring snapshots are the remaining ones.
+func BuildPrunePolicies(metricsMonths int, downsampleMonths int, gc bool) []PrunePolicy {
+	var policies []PrunePolicy
+
+	if metricsMonths > 0 {
+		cutoff := monthsAgoDLTS(metricsMonths)
+		policies = append(policies, PrunePolicy{
+			description: fmt.Sprintf("snapshots older than %d months", metricsMonths),
+			table:       "TorQueries",
+			where:       "AcquisitionTimestamp < ?",
+			args:        []interface{}{cutoff},
+		})
+	}
+
+	if downsampleMonths > 0 {
+		// Keep the first snapshot of every day. The extra derived table is needed
+		// as MySQL does not allow a DELETE to select from its target table directly.
+		cutoff := monthsAgoDLTS(downsampleMonths)
+		policies = append(policies, PrunePolicy{
+			description: fmt.Sprintf("snapshots older than %d months downsampled to daily", downsampleMonths),
+			table:       "TorQueries",
+			where: "AcquisitionTimestamp < ? AND AcquisitionTimestamp NOT IN " +
+				"(SELECT ts FROM (SELECT min(AcquisitionTimestamp) ts FROM TorQueries GROUP BY DATE(AcquisitionTimestamp)) AS daily)",
+			args: []interface{}{cutoff},
+		})
+	}
+
+	if metricsMonths > 0 || downsampleMonths > 0 {
+		policies = append(policies, PrunePolicy{
+			description: "network summaries of removed snapshots",
+			table:       "NetworkSummaries",
+			where:       "ID_TorQueries NOT IN (SELECT ID FROM TorQueries)",
+		})
+	}
+
+	if gc {
+		for _, lt := range pruneLookupTables {
+			policies = append(policies, PrunePolicy{
+				description: "orphaned " + lt.table,
+				table:       lt.table,
+				where:       fmt.Sprintf("ID NOT IN (SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL)", lt.refColumn, lt.refTable, lt.refColumn),
+			})
+		}
+		for _, dt := range pruneDetailTables {
+			policies = append(policies, PrunePolicy{
+				description: dt.table + " of missing " + dt.lookupTable,
+				table:       dt.table,
+				where:       fmt.Sprintf("%s NOT IN (SELECT ID FROM %s)", dt.column, dt.lookupTable),
+			})
+		}
+	}
+	return policies
+}
+
+// Applies the retention policies in a single transaction. In dry run mode the same
+// rows are removed and the transaction is rolled back, so that the counts account for
+// the policies applying after each other (e.g. rows matched by two policies).
+func (db *DB) Prune(policies []PrunePolicy, dryRun bool) {
+	ifPrintln(3, "func prune: START")
+	defer ifPrintln(3, "func prune: END")
+
+	if !db.Initialized() {
+		log.Fatal("Call to a method in uninitialized database.")
+	}
+
+	tx, err := db.dbh.Begin()
+	if err != nil {
+		panic("func prune: " + err.Error())
+	}
+	defer tx.Rollback() // No-op after a successful commit
+
+	var total int64
+	for _, p := range policies {
+		res, err := tx.Exec("DELETE FROM "+p.table+" WHERE "+p.where, p.args...)
+		if err != nil {
+			panic("func prune: (" + p.description + ") " + err.Error())
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			panic("func prune: (" + p.description + ") " + err.Error())
+		}
+		fmt.Printf("%-60s %s: %d rows\n", p.description, p.table, count)
+		total += count
+	}
+
+	if dryRun {
+		if err = tx.Rollback(); err != nil {
+			panic("func prune: rollback: " + err.Error())
+		}
+		fmt.Printf("Dry run: %d rows would be removed.\n", total)
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		panic("func prune: commit: " + err.Error())
+	}
+	fmt.Printf("Removed %d rows.\n", total)
+}
@@ -0,0 +1,33 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import "testing"
+
+// Details are removed after their lookup rows, in the same prune or check -repair run
+func TestPruneGCRemovesContactDetails(t *testing.T) {
+	contacts, details := -1, -1
+	for i, p := range BuildPrunePolicies(0, 0, true) {
+		switch p.table {
+		case "Contacts":
+			contacts = i
+		case "ContactDetails":
+			details = i
+		}
+	}
+	if contacts < 0 || details < contacts {
+		t.Errorf("ContactDetails policy at %d, Contacts policy at %d", details, contacts)
+	}
+
+	for _, c := range BuildConsistencyChecks() {
+		if c.description == "ContactDetails of missing Contacts" && c.repair != nil {
+			return
+		}
+	}
+	t.Error("no repairable check of ContactDetails of missing Contacts")
+}
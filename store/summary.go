@@ -0,0 +1,48 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"encoding/json"
+)
+
+// Network-wide aggregates of a snapshot, stored in NetworkSummaries
+type NetworkSummary struct {
+	Relays                  uint64
+	Running                 uint64
+	Exits                   uint64
+	Guards                  uint64
+	AdvertisedBandwidth     uint64 // bytes/s
+	ExitAdvertisedBandwidth uint64 // bytes/s, relays with the Exit flag
+	ConsensusWeight         uint64
+	ExitConsensusWeight     uint64
+	Flags                   map[string]uint64
+	Countries               map[string]uint64
+	ASes                    map[string]uint64
+	Versions                map[string]uint64
+}
+
+func (db *DB) AddNetworkSummary(tqid string, ns *NetworkSummary) {
+	ifPrintln(4, "func AddNetworkSummary("+tqid+"): ")
+	defer ifPrintln(4, "func AddNetworkSummary: RETURN")
+
+	var maps [4][]byte
+	for i, m := range []map[string]uint64{ns.Flags, ns.Countries, ns.ASes, ns.Versions} {
+		var err error
+		if maps[i], err = json.Marshal(m); err != nil {
+			panic("func AddNetworkSummary: " + err.Error())
+		}
+	}
+
+	_, err := db.stmtAddNetworkSummary.Exec(tqid, ns.Relays, ns.Running, ns.Exits, ns.Guards,
+		ns.AdvertisedBandwidth, ns.ExitAdvertisedBandwidth, ns.ConsensusWeight, ns.ExitConsensusWeight,
+		string(maps[0]), string(maps[1]), string(maps[2]), string(maps[3]))
+	if err != nil {
+		panic("func AddNetworkSummary: " + err.Error())
+	}
+}
@@ -0,0 +1,104 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// Time format of the timestamps returned by the queries and used by the history files
+const TimeFmt = "2006-01-02 15:04:05"
+
+// Consensus download timestamp format (DLTS), also used by the caches
+const DLTSFmt = "20060102150405"
+
+// Bounds of an unrestricted [from, to] time range
+const (
+	MinTime = "1970-01-01 00:00:00"
+	MaxTime = "9999-12-31 23:59:59"
+)
+
+// Timestamp formats recognized in file names and on the command line
+var TimeFormats = []string{"2006-01-02_15:04:05", "2006-01-02_15:04", "20060102150405", "200601021504",
+	"2006-01-02-15-04-05", "2006-01-02-15-04", time.RFC3339, time.RFC3339Nano, time.ANSIC, time.UnixDate,
+	time.RFC822, time.RFC822Z, time.RFC850, time.RFC1123, time.RFC1123Z, time.RubyDate}
+
+func MatchTimestampToFormats(ts_matches []string, formats []string) *time.Time {
+	// Given an array of potential timestamps and possible time formats it returns
+	// a match for the first TS that matches a time format
+	var err error
+	var t time.Time
+
+	for _, ts := range ts_matches {
+		ifPrintln(6, "Matching against: "+ts)
+		for _, f := range formats {
+			ifPrintln(6, "Attempting format: "+f)
+			t, err = time.Parse(f, ts)
+			if err == nil {
+				ifPrintln(6, "Match found! ^^^")
+				return &t
+			}
+		}
+	}
+	return nil
+}
+
+// Parses a -from/-to style argument to TimeFmt, in UTC. Accepts the consensus timestamp
+// formats as well as plain dates. An empty ts returns def.
+func ParseTime(ts string, def string) (string, error) {
+	return parseTime(ts, def, false)
+}
+
+// Parses the end of a time range (-to) as ParseTime, except that a plain date stands
+// for the end of that day
+func ParseEndTime(ts string, def string) (string, error) {
+	return parseTime(ts, def, true)
+}
+
+func parseTime(ts string, def string, endOfDay bool) (string, error) {
+	if ts == "" {
+		return def, nil
+	}
+	if t, err := time.Parse("2006-01-02", ts); err == nil {
+		if endOfDay {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		return t.Format(TimeFmt), nil
+	}
+	t := MatchTimestampToFormats([]string{ts}, append([]string{TimeFmt}, TimeFormats...))
+	if t == nil {
+		return "", errors.New("unable to parse timestamp: " + ts)
+	}
+	return t.UTC().Format(TimeFmt), nil
+}
+
+// Resolves the -from/-to/-at arguments of a lookup into a [from, to] range. at is a point
+// in time (from == to) and excludes from and to; missing bounds are unrestricted. A
+// plain date as to includes that whole day.
+func ParseTimeRange(from string, to string, at string) (string, string, error) {
+	if at != "" {
+		if from != "" || to != "" {
+			return "", "", errors.New("a point in time (at) cannot be combined with from/to")
+		}
+		ts, err := ParseTime(at, "")
+		return ts, ts, err
+	}
+	tsFrom, err := ParseTime(from, MinTime)
+	if err != nil {
+		return "", "", err
+	}
+	tsTo, err := ParseEndTime(to, MaxTime)
+	if err != nil {
+		return "", "", err
+	}
+	if tsFrom > tsTo {
+		return "", "", errors.New("from (" + tsFrom + ") is after to (" + tsTo + ")")
+	}
+	return tsFrom, tsTo, nil
+}
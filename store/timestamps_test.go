@@ -0,0 +1,71 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import "testing"
+
+func TestParseTime(t *testing.T) {
+	for _, tc := range []struct {
+		ts, start, end string
+	}{
+		{"2019-03-31", "2019-03-31 00:00:00", "2019-03-31 23:59:59"},
+		{"2019-03-31 12:30:00", "2019-03-31 12:30:00", "2019-03-31 12:30:00"},
+		{"2019-03-31_12:30", "2019-03-31 12:30:00", "2019-03-31 12:30:00"},
+		{"20190331123000", "2019-03-31 12:30:00", "2019-03-31 12:30:00"},
+		{"2019-03-01T00:00:00+02:00", "2019-02-28 22:00:00", "2019-02-28 22:00:00"},
+		{"2019-03-01T00:00:00Z", "2019-03-01 00:00:00", "2019-03-01 00:00:00"},
+		{"01 Mar 19 00:00 -0500", "2019-03-01 05:00:00", "2019-03-01 05:00:00"},
+		{"", "default", "default"},
+	} {
+		if got, err := ParseTime(tc.ts, "default"); err != nil || got != tc.start {
+			t.Errorf("ParseTime(%q) = %q, %v; want %q", tc.ts, got, err, tc.start)
+		}
+		if got, err := ParseEndTime(tc.ts, "default"); err != nil || got != tc.end {
+			t.Errorf("ParseEndTime(%q) = %q, %v; want %q", tc.ts, got, err, tc.end)
+		}
+	}
+
+	for _, ts := range []string{"March 2019", "2019-02-30", "yesterday"} {
+		if got, err := ParseTime(ts, ""); err == nil {
+			t.Errorf("ParseTime(%q) = %q, want an error", ts, got)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, at     string
+		wantFrom, wantTo string
+	}{
+		{"", "", "", MinTime, MaxTime},
+		{"2019-03-01", "2019-03-31", "", "2019-03-01 00:00:00", "2019-03-31 23:59:59"},
+		{"2019-03-31", "2019-03-31", "", "2019-03-31 00:00:00", "2019-03-31 23:59:59"},
+		{"2019-03-01", "", "", "2019-03-01 00:00:00", MaxTime},
+		{"", "2019-03-31_12:00", "", MinTime, "2019-03-31 12:00:00"},
+		{"", "", "2019-03-15", "2019-03-15 00:00:00", "2019-03-15 00:00:00"},
+		{"", "", "2019-03-15T12:00:00+01:00", "2019-03-15 11:00:00", "2019-03-15 11:00:00"},
+	} {
+		from, to, err := ParseTimeRange(tc.from, tc.to, tc.at)
+		if err != nil || from != tc.wantFrom || to != tc.wantTo {
+			t.Errorf("ParseTimeRange(%q, %q, %q) = %q, %q, %v; want %q, %q", tc.from, tc.to, tc.at, from, to, err, tc.wantFrom, tc.wantTo)
+		}
+	}
+
+	for _, tc := range [][3]string{
+		{"2019-03-01", "", "2019-03-15"},
+		{"", "2019-03-31", "2019-03-15"},
+		{"2019-04-01", "2019-03-31", ""},
+		{"x", "", ""},
+		{"", "x", ""},
+		{"", "", "x"},
+	} {
+		if _, _, err := ParseTimeRange(tc[0], tc[1], tc[2]); err == nil {
+			t.Errorf("ParseTimeRange(%q, %q, %q) accepted an invalid range", tc[0], tc[1], tc[2])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+)
+
+// Version_status and Recommended_version column values of the relay; nil when omitted
+func VersionStatusValues(relay onionoo.TorRelayDetails) (interface{}, interface{}) {
+	var status, recommended interface{}
+	if relay.Version_status != "" {
+		status = relay.Version_status
+	}
+	if relay.Recommended_version != nil {
+		recommended = *relay.Recommended_version
+	}
+	return status, recommended
+}
+
+// Onionoo form of a Recommended_version column value: "true", "false" or "" when unknown
+func recommendedString(value string) string {
+	switch value {
+	case "1":
+		return "true"
+	case "0":
+		return "false"
+	}
+	return value
+}
+
+func recommendedVersionString(recommended *bool) string {
+	if recommended == nil {
+		return ""
+	}
+	return strconv.FormatBool(*recommended)
+}
+
+// Returns the latest TorRelay record, within the [from, to] time range, of every relay
+// which ran the Tor version in that range. A version without its last component, e.g.
+// "0.4.5", also matches the releases of the series ("0.4.5.7", "0.4.5.8", ...).
+func (db *DB) GetLatestTRsIDsByVersion(version string, from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByVersion: "+version+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByVersion: END")
+
+	version = strings.TrimPrefix(strings.TrimSpace(version), "Tor ")
+	if len(version) == 0 {
+		return make(map[string]string)
+	}
+	ids := db.SQLQueryKeyValue("SELECT ID, VersionName FROM Versions WHERE VersionName = ? OR VersionName LIKE ?;",
+		version, db.escapeLikeWildcards(version)+".%")
+	return db.getLatestTRsIDsByLookupIDs("ID_Versions", ids, from, to)
+}
+
+// Returns the latest TorRelay record, within the [from, to] time range, of every relay
+// whose platform string contains platform in that range, e.g. "Linux" or "Tor 0.4.5"
+func (db *DB) GetLatestTRsIDsByPlatform(platform string, from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByPlatform: "+platform+"; "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByPlatform: END")
+
+	platform = strings.TrimSpace(platform)
+	if len(platform) == 0 {
+		return make(map[string]string)
+	}
+	ids := db.SQLQueryKeyValue("SELECT ID, PlatformName FROM Platforms WHERE PlatformName LIKE ?;",
+		"%"+db.escapeLikeWildcards(platform)+"%")
+	return db.getLatestTRsIDsByLookupIDs("ID_Platforms", ids, from, to)
+}
+
+// Latest TorRelay record, within the [from, to] time range, of every relay with one of
+// the lookup table IDs (the keys of ids) in column. Only called with constant column names.
+func (db *DB) getLatestTRsIDsByLookupIDs(column string, ids map[string]string, from string, to string) map[string]string {
+	var args []interface{}
+	for id := range ids {
+		args = append(args, id)
+	}
+
+	result := make(map[string]string)
+	for len(args) > 0 {
+		n := len(args)
+		if n > inListChunkSize {
+			n = inListChunkSize
+		}
+		query := `SELECT max(ID) as ID, ID_NodeFingerprints FROM TorRelays
+			WHERE RecordLastSeen >= ? AND RecordTimeInserted <= ? AND ` + column + ` IN (?` + strings.Repeat(", ?", n-1) + `)
+			GROUP BY ID_NodeFingerprints;`
+		for id, fpid := range db.SQLQueryKeyValue(query, append([]interface{}{from, to}, args[:n]...)...) {
+			result[id] = fpid
+		}
+		args = args[n:]
+	}
+	return mergeLatestByFpid(result)
+}
+
+// Keeps the latest (highest) TorRelays ID of each relay in a TorRelays ID => NodeFingerprints ID map
+func mergeLatestByFpid(ids map[string]string) map[string]string {
+	latest := make(map[string]int)
+	for id, fpid := range ids {
+		if trid, _ := strconv.Atoi(id); trid > latest[fpid] {
+			latest[fpid] = trid
+		}
+	}
+	result := make(map[string]string)
+	for fpid, trid := range latest {
+		result[strconv.Itoa(trid)] = fpid
+	}
+	return result
+}
+
+// Returns the latest TorRelay record, within the [from, to] time range, of every relay
+// which ran an obsolete or unrecommended Tor version in that range. Records without a
+// Version_status (before April 2018) match when the version was not recommended. Records
+// with neither, whose stored details do not tell (e.g. imported before the columns were
+// added and without the fields in the details), are never reported.
+func (db *DB) GetLatestTRsIDsByUnrecommendedVersion(from string, to string) map[string]string {
+	ifPrintln(3, "func GetLatestTRsIDsByUnrecommendedVersion: "+from+" - "+to)
+	defer ifPrintln(3, "func GetLatestTRsIDsByUnrecommendedVersion: END")
+
+	return db.SQLQueryKeyValue(`SELECT max(ID) as ID, ID_NodeFingerprints FROM TorRelays
+		WHERE RecordLastSeen >= ? AND RecordTimeInserted <= ?
+		AND (Version_status IN ('obsolete', 'unrecommended') OR (Version_status IS NULL AND Recommended_version = FALSE))
+		GROUP BY ID_NodeFingerprints;`, from, to)
+}
+
+// Number of relays running each Tor version at the time, most used first. Each row holds
+// VersionName, Version_status and Relays.
+func (db *DB) GetVersionDistribution(at string) [](map[string]string) {
+	ifPrintln(3, "func GetVersionDistribution: "+at)
+	defer ifPrintln(3, "func GetVersionDistribution: END")
+
+	return db.SQLQueryTYPEOfMaps("sliceOfMaps", `SELECT VersionName, max(Version_status) Version_status,
+		COUNT(DISTINCT ID_NodeFingerprints) Relays
+		FROM TorRelays tr LEFT JOIN Versions v ON tr.ID_Versions = v.ID
+		WHERE tr.RecordTimeInserted <= ? AND tr.RecordLastSeen >= ?
+		GROUP BY VersionName ORDER BY Relays DESC, VersionName;`, at, at).([](map[string]string))
+}
@@ -0,0 +1,63 @@
+/*****************************************************************************************
+** TOR History                                                                          **
+** (C) Krassimir Tzvetanov                                                              **
+** Distributed under Attribution-NonCommercial-ShareAlike 4.0 International             **
+** https://creativecommons.org/licenses/by-nc-sa/4.0/legalcode                          **
+*****************************************************************************************/
+
+package store
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Harsh-bartariya/tor-history/onionoo"
+)
+
+func TestVersionStatusValues(t *testing.T) {
+	for js, want := range map[string][2]interface{}{
+		`{}`: {nil, nil},
+		`{"version_status":"obsolete","recommended_version":false}`:   {"obsolete", false},
+		`{"version_status":"recommended","recommended_version":true}`: {"recommended", true},
+	} {
+		var relay onionoo.TorRelayDetails
+		if err := json.Unmarshal([]byte(js), &relay); err != nil {
+			t.Fatal(err)
+		}
+		status, recommended := VersionStatusValues(relay)
+		if status != want[0] || recommended != want[1] {
+			t.Errorf("%s: got %v, %v; want %v, %v", js, status, recommended, want[0], want[1])
+		}
+
+		// Stored details keep a false Recommended_version
+		stored, _ := json.Marshal(relay)
+		var back onionoo.TorRelayDetails
+		json.Unmarshal(stored, &back)
+		if s, r := VersionStatusValues(back); s != status || r != recommended {
+			t.Errorf("%s: stored as %s", js, stored)
+		}
+	}
+}
+
+func TestVersionStatusChangesAreDetected(t *testing.T) {
+	recommended := true
+	relay := onionoo.TorRelayDetails{Version: "0.4.5.7", Version_status: "recommended", Recommended_version: &recommended}
+	lrd := map[string]string{"VersionName": "0.4.5.7", "Version_status": "obsolete", "Recommended_version": "0"}
+
+	var changes []FieldChange
+	for _, c := range DiffRelayFields(LRDFieldValues(lrd), RelayFieldValues(relay)) {
+		if c.Field == "Version" || c.Field == "Version_status" || c.Field == "Recommended_version" {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) != 2 || changes[0].Field != "Version_status" || changes[1] != (FieldChange{"Recommended_version", "false", "true"}) {
+		t.Errorf("got %v", changes)
+	}
+}
+
+func TestMergeLatestByFpid(t *testing.T) {
+	got := mergeLatestByFpid(map[string]string{"9": "1", "12": "1", "3": "2"})
+	if len(got) != 2 || got["12"] != "1" || got["3"] != "2" {
+		t.Errorf("got %v", got)
+	}
+}